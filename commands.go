@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/husio/plopper/plopper"
+)
+
+// command is a plopper subcommand.
+type command struct {
+	name    string
+	args    string
+	summary string
+	// setup registers command specific flags and returns the function
+	// that executes the command with remaining positional arguments.
+	setup func(fs *flag.FlagSet) func(ctx context.Context, conf Config, args []string) error
+}
+
+// errUsage is returned by a command when it is called with invalid arguments.
+var errUsage = errors.New("invalid usage")
+
+var commands = []*command{
+	{
+		name:    "serve",
+		summary: "Run the HTTP server. This is the default command.",
+		setup: func(fs *flag.FlagSet) func(context.Context, Config, []string) error {
+			return runServe
+		},
+	},
+	{
+		name:    "migrate",
+		summary: "Apply all pending database migrations.",
+		setup: func(fs *flag.FlagSet) func(context.Context, Config, []string) error {
+			return runMigrate
+		},
+	},
+	{
+		name:    "export",
+		summary: "Write all plops as JSON Lines to the standard output or a file.",
+		setup: func(fs *flag.FlagSet) func(context.Context, Config, []string) error {
+			output := fs.String("o", "", "Output file path. Standard output if not provided.")
+			return func(ctx context.Context, conf Config, args []string) error {
+				return runExport(ctx, conf, args, *output)
+			}
+		},
+	},
+	{
+		name:    "import",
+		args:    "[file]",
+		summary: "Read plops as JSON Lines from a file or the standard input. Plops that already exist are skipped.",
+		setup: func(fs *flag.FlagSet) func(context.Context, Config, []string) error {
+			return runImport
+		},
+	},
+	{
+		name:    "purge",
+		summary: "Permanently delete all plops older than given age.",
+		setup: func(fs *flag.FlagSet) func(context.Context, Config, []string) error {
+			olderThan := fs.Duration("older-than", 0, "Delete plops created before this long ago. Required.")
+			return func(ctx context.Context, conf Config, args []string) error {
+				return runPurge(ctx, conf, args, *olderThan)
+			}
+		},
+	},
+	{
+		name:    "delete-plop",
+		args:    "<id>",
+		summary: "Permanently delete a single plop. The deletion is recorded in the moderation log.",
+		setup: func(fs *flag.FlagSet) func(context.Context, Config, []string) error {
+			note := fs.String("note", "", "Note recorded in the moderation log.")
+			return func(ctx context.Context, conf Config, args []string) error {
+				return runDeletePlop(ctx, conf, args, *note)
+			}
+		},
+	},
+	{
+		name:    "backup",
+		summary: "Take a verified database snapshot in the backup directory.",
+		setup: func(fs *flag.FlagSet) func(context.Context, Config, []string) error {
+			return runBackup
+		},
+	},
+	{
+		name:    "restore",
+		args:    "[snapshot]",
+		summary: "Replace the database with a verified snapshot, by default the newest one in the backup directory. The database must not be used, so stop the server first.",
+		setup: func(fs *flag.FlagSet) func(context.Context, Config, []string) error {
+			return runRestore
+		},
+	},
+	{
+		name:    "stats",
+		summary: "Print a summary of the store content.",
+		setup: func(fs *flag.FlagSet) func(context.Context, Config, []string) error {
+			return runStats
+		},
+	},
+	{
+		name:    "seed",
+		summary: "Create random plops for local development.",
+		setup: func(fs *flag.FlagSet) func(context.Context, Config, []string) error {
+			n := fs.Int("n", 100, "Number of plops to create.")
+			return func(ctx context.Context, conf Config, args []string) error {
+				return runSeed(ctx, conf, args, *n)
+			}
+		},
+	},
+}
+
+func findCommand(name string) (*command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: plopper <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.summary)
+	}
+	_ = tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "plopper <command> -help" to list flags of a command.`)
+}
+
+// openStore returns the store described by the configuration. Migrations are
+// applied when the store is opened.
+func openStore(conf Config) (plopper.PlopStore, error) {
+	store, err := plopper.OpenSQLitePlopStore(conf.Database)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open plops store: %w", err)
+	}
+	return store, nil
+}
+
+// withStore calls fn with an open store and closes the store afterwards.
+func withStore(conf Config, fn func(plopper.PlopStore) error) error {
+	store, err := openStore(conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			log.Printf("cannot close plops store: %s", err)
+		}
+	}()
+	return fn(store)
+}
+
+func runMigrate(ctx context.Context, conf Config, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return withStore(conf, func(store plopper.PlopStore) error {
+		log.Printf("Database %s schema is up to date.", conf.Database)
+		return nil
+	})
+}
+
+func runExport(ctx context.Context, conf Config, args []string, output string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return withStore(conf, func(store plopper.PlopStore) error {
+		var w io.Writer = os.Stdout
+		if output != "" {
+			fd, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("cannot create output file: %w", err)
+			}
+			defer fd.Close()
+			w = fd
+		}
+		bw := bufio.NewWriter(w)
+		n, err := plopper.ExportPlops(ctx, store, bw)
+		if err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		if err := bw.Flush(); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		log.Printf("Exported %d plops.", n)
+		return nil
+	})
+}
+
+func runImport(ctx context.Context, conf Config, args []string) error {
+	var r io.Reader = os.Stdin
+	switch len(args) {
+	case 0:
+	case 1:
+		fd, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("cannot open input file: %w", err)
+		}
+		defer fd.Close()
+		r = fd
+	default:
+		return errUsage
+	}
+	return withStore(conf, func(store plopper.PlopStore) error {
+		res, err := plopper.ImportPlops(ctx, store, r)
+		if err != nil {
+			return fmt.Errorf("import after %d plops: %w", res.Imported+res.Skipped, err)
+		}
+		log.Printf("Imported %d plops, skipped %d already present.", res.Imported, res.Skipped)
+		return nil
+	})
+}
+
+func runPurge(ctx context.Context, conf Config, args []string, olderThan time.Duration) error {
+	if len(args) != 0 || olderThan <= 0 {
+		return errUsage
+	}
+	return withStore(conf, func(store plopper.PlopStore) error {
+		n, err := store.PurgePlops(ctx, time.Now().Add(-olderThan))
+		if err != nil {
+			return fmt.Errorf("purge: %w", err)
+		}
+		log.Printf("Deleted %d plops older than %s.", n, olderThan)
+		return nil
+	})
+}
+
+func runDeletePlop(ctx context.Context, conf Config, args []string, note string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := hex.DecodeString(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid plop ID %q", args[0])
+	}
+	return withStore(conf, func(store plopper.PlopStore) error {
+		err := store.ApplyModerationAction(ctx, &plopper.ModerationAction{
+			PlopID:      id,
+			ModeratorID: "cli",
+			Action:      plopper.ActionDelete,
+			Note:        note,
+		})
+		if errors.Is(err, plopper.ErrNotFound) {
+			return fmt.Errorf("plop %s does not exist", args[0])
+		}
+		if err != nil {
+			return fmt.Errorf("cannot delete plop: %w", err)
+		}
+		log.Printf("Deleted plop %s.", args[0])
+		return nil
+	})
+}
+
+func runBackup(ctx context.Context, conf Config, args []string) error {
+	if len(args) != 0 || conf.Backup.Dir == "" {
+		return errUsage
+	}
+	return withStore(conf, func(store plopper.PlopStore) error {
+		snapshotter, ok := store.(plopper.Snapshotter)
+		if !ok {
+			return errors.New("store does not support backups")
+		}
+		backups := &plopper.Backups{Store: snapshotter, Dir: conf.Backup.Dir, Keep: conf.Backup.Keep}
+		path, err := backups.Snapshot(ctx)
+		if err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+		log.Printf("Snapshot %s taken.", path)
+		return nil
+	})
+}
+
+func runRestore(ctx context.Context, conf Config, args []string) error {
+	var snapshot string
+	switch len(args) {
+	case 0:
+		if conf.Backup.Dir == "" {
+			return errUsage
+		}
+		snapshots, err := plopper.ListSnapshots(conf.Backup.Dir)
+		if err != nil {
+			return fmt.Errorf("cannot list snapshots: %w", err)
+		}
+		if len(snapshots) == 0 {
+			return fmt.Errorf("no snapshots in %s", conf.Backup.Dir)
+		}
+		snapshot = snapshots[len(snapshots)-1]
+	case 1:
+		snapshot = args[0]
+	default:
+		return errUsage
+	}
+	if err := plopper.RestoreSnapshot(ctx, snapshot, conf.Database); err != nil {
+		if errors.Is(err, plopper.ErrDatabaseInUse) {
+			return fmt.Errorf("restore: %w, stop the server first", err)
+		}
+		return fmt.Errorf("restore: %w", err)
+	}
+	log.Printf("Database %s restored from %s.", conf.Database, snapshot)
+	return nil
+}
+
+func runStats(ctx context.Context, conf Config, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return withStore(conf, func(store plopper.PlopStore) error {
+		stats, err := store.Stats(ctx)
+		if err != nil {
+			return fmt.Errorf("stats: %w", err)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		statuses := make([]string, 0, len(stats.Plops))
+		for status := range stats.Plops {
+			statuses = append(statuses, string(status))
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(tw, "plops %s\t%d\n", status, stats.Plops[plopper.PlopStatus(status)])
+		}
+		fmt.Fprintf(tw, "authors\t%d\n", stats.Authors)
+		fmt.Fprintf(tw, "open reports\t%d\n", stats.OpenReports)
+		fmt.Fprintf(tw, "follows\t%d\n", stats.Follows)
+		fmt.Fprintf(tw, "mutes and blocks\t%d\n", stats.Relations)
+		if !stats.Oldest.IsZero() {
+			fmt.Fprintf(tw, "oldest plop\t%s\n", stats.Oldest.Format(time.RFC3339))
+			fmt.Fprintf(tw, "newest plop\t%s\n", stats.Newest.Format(time.RFC3339))
+		}
+		return tw.Flush()
+	})
+}
+
+func runSeed(ctx context.Context, conf Config, args []string, n int) error {
+	if len(args) != 0 || n < 1 {
+		return errUsage
+	}
+	words := strings.Fields(`
+		plop blop glorp splash drip drop puddle pond ripple wave bubble
+		fizz foam splish splosh gurgle trickle stream river rain cloud
+	`)
+	return withStore(conf, func(store plopper.PlopStore) error {
+		for i := 0; i < n; i++ {
+			content := make([]string, 3+rand.Intn(12))
+			for j := range content {
+				content[j] = words[rand.Intn(len(words))]
+			}
+			author := fmt.Sprintf("seed-%d", rand.Intn(10))
+			if _, err := store.Create(ctx, author, strings.Join(content, " "), plopper.PlopPublished); err != nil {
+				return fmt.Errorf("cannot create plop: %w", err)
+			}
+		}
+		log.Printf("Created %d plops.", n)
+		return nil
+	})
+}
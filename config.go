@@ -0,0 +1,436 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/husio/plopper/logging"
+	"github.com/husio/plopper/plopper"
+)
+
+// Config is the configuration of the plopper server.
+//
+// Configuration is loaded from environment variables, then from an optional
+// TOML or YAML file and then from command line flags. Each source overrides
+// values provided by the previous one.
+type Config struct {
+	Port     string `toml:"port" yaml:"port"`
+	LithAPI  string `toml:"lith_api" yaml:"lith_api"`
+	LoginURL string `toml:"login_url" yaml:"login_url"`
+	Database string `toml:"database" yaml:"database"`
+	// Secret is used to sign CSRF tokens. If empty, a random secret is
+	// generated on each start.
+	Secret string `toml:"secret" yaml:"secret"`
+	// MetricsPort is the port of a separate HTTP server that serves
+	// metrics, so that they are not public. Empty disables metrics.
+	MetricsPort string `toml:"metrics_port" yaml:"metrics_port"`
+
+	LogFormat string `toml:"log_format" yaml:"log_format"`
+	// TraceExport is empty to disable exporting, "stdout" or a file path.
+	TraceExport     string   `toml:"trace_export" yaml:"trace_export"`
+	ModerationWords []string `toml:"moderation_words" yaml:"moderation_words"`
+	// TemplateDir is empty to use the embedded templates. Otherwise
+	// templates are loaded from that directory whenever they change.
+	TemplateDir string `toml:"template_dir" yaml:"template_dir"`
+
+	HTTP        HTTPConfig        `toml:"http" yaml:"http"`
+	Limits      LimitsConfig      `toml:"limits" yaml:"limits"`
+	Permissions PermissionsConfig `toml:"permissions" yaml:"permissions"`
+	Backup      BackupConfig      `toml:"backup" yaml:"backup"`
+}
+
+type HTTPConfig struct {
+	ReadTimeout       time.Duration `toml:"read_timeout" yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `toml:"read_header_timeout" yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `toml:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout       time.Duration `toml:"idle_timeout" yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `toml:"shutdown_timeout" yaml:"shutdown_timeout"`
+}
+
+type LimitsConfig struct {
+	PlopsPerPage     int           `toml:"plops_per_page" yaml:"plops_per_page"`
+	MinContentLength int           `toml:"min_content_length" yaml:"min_content_length"`
+	MaxContentLength int           `toml:"max_content_length" yaml:"max_content_length"`
+	MaxNewlines      int           `toml:"max_newlines" yaml:"max_newlines"`
+	CreateRateLimit  int           `toml:"create_rate_limit" yaml:"create_rate_limit"`
+	CreateRateWindow time.Duration `toml:"create_rate_window" yaml:"create_rate_window"`
+}
+
+type PermissionsConfig struct {
+	Create   string `toml:"create" yaml:"create"`
+	Moderate string `toml:"moderate" yaml:"moderate"`
+}
+
+type BackupConfig struct {
+	// Dir is the directory where database snapshots are kept. Empty
+	// disables scheduled backups.
+	Dir      string        `toml:"dir" yaml:"dir"`
+	Interval time.Duration `toml:"interval" yaml:"interval"`
+	// Keep is the number of the newest snapshots that are kept.
+	Keep int `toml:"keep" yaml:"keep"`
+}
+
+// DefaultConfig returns the configuration used when nothing is provided.
+func DefaultConfig() Config {
+	settings := plopper.DefaultSettings()
+	return Config{
+		Port:      "8000",
+		LithAPI:   "https://lith-demo.herokuapp.com/api",
+		LoginURL:  "https://lith-demo.herokuapp.com/pub/",
+		Database:  "/tmp/plopper.sqlite3",
+		LogFormat: "logfmt",
+		HTTP: HTTPConfig{
+			ReadTimeout:       10 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			// Heroku sends SIGKILL 30 seconds after SIGTERM.
+			ShutdownTimeout: 25 * time.Second,
+		},
+		Limits: LimitsConfig{
+			PlopsPerPage:     settings.PlopsPerPage,
+			MinContentLength: settings.MinContentLength,
+			MaxContentLength: settings.MaxContentLength,
+			MaxNewlines:      settings.MaxNewlines,
+			CreateRateLimit:  settings.CreateRateLimit,
+			CreateRateWindow: settings.CreateRateWindow,
+		},
+		Permissions: PermissionsConfig{
+			Create:   settings.CreatePermission,
+			Moderate: settings.ModeratePermission,
+		},
+		Backup: BackupConfig{
+			Interval: time.Hour,
+			Keep:     24,
+		},
+	}
+}
+
+// configVar describes a configuration value that can be set using an
+// environment variable and a command line flag.
+type configVar struct {
+	env   string
+	flag  string
+	usage string
+	value flag.Value
+}
+
+func (c *Config) vars() []configVar {
+	return []configVar{
+		{"PORT", "port", "HTTP server port.", (*stringValue)(&c.Port)},
+		{"METRICS_PORT", "metrics-port", "Port of the HTTP server that serves Prometheus metrics at /metrics. Metrics are not served if empty.", (*stringValue)(&c.MetricsPort)},
+		{"LITH_API", "lith-api", "Lith API address.", (*stringValue)(&c.LithAPI)},
+		{"LOGIN_URL", "login-url", "Lith user interface address.", (*stringValue)(&c.LoginURL)},
+		{"DATABASE", "database", "SQLite database file path.", (*stringValue)(&c.Database)},
+		{"SECRET", "secret", "Secret used to sign CSRF tokens.", (*stringValue)(&c.Secret)},
+		{"LOG_FORMAT", "log-format", "Log format, logfmt or json.", (*stringValue)(&c.LogFormat)},
+		{"TRACE_EXPORT", "trace-export", `Trace export destination, "stdout" or a file path.`, (*stringValue)(&c.TraceExport)},
+		{"MODERATION_WORDS", "moderation-words", "Comma separated words that hold a plop for moderation.", (*listValue)(&c.ModerationWords)},
+		{"TEMPLATE_DIR", "template-dir", "Directory to reload templates from whenever they change, for development. Embedded templates are used if empty.", (*stringValue)(&c.TemplateDir)},
+
+		{"HTTP_READ_TIMEOUT", "http-read-timeout", "HTTP server read timeout.", (*durationValue)(&c.HTTP.ReadTimeout)},
+		{"HTTP_READ_HEADER_TIMEOUT", "http-read-header-timeout", "HTTP server read header timeout.", (*durationValue)(&c.HTTP.ReadHeaderTimeout)},
+		{"HTTP_WRITE_TIMEOUT", "http-write-timeout", "HTTP server write timeout.", (*durationValue)(&c.HTTP.WriteTimeout)},
+		{"HTTP_IDLE_TIMEOUT", "http-idle-timeout", "HTTP server idle timeout.", (*durationValue)(&c.HTTP.IdleTimeout)},
+		{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "Time given to in-flight requests on shutdown.", (*durationValue)(&c.HTTP.ShutdownTimeout)},
+
+		{"PLOPS_PER_PAGE", "plops-per-page", "Number of plops displayed on a single page.", (*intValue)(&c.Limits.PlopsPerPage)},
+		{"MIN_CONTENT_LENGTH", "min-content-length", "Minimum plop length in characters.", (*intValue)(&c.Limits.MinContentLength)},
+		{"MAX_CONTENT_LENGTH", "max-content-length", "Maximum plop length in characters.", (*intValue)(&c.Limits.MaxContentLength)},
+		{"MAX_NEWLINES", "max-newlines", "Consecutive line breaks kept in plop content.", (*intValue)(&c.Limits.MaxNewlines)},
+		{"CREATE_RATE_LIMIT", "create-rate-limit", "Plops an account can create within the rate window, 0 for no limit.", (*intValue)(&c.Limits.CreateRateLimit)},
+		{"CREATE_RATE_WINDOW", "create-rate-window", "Plop creation rate limit window.", (*durationValue)(&c.Limits.CreateRateWindow)},
+
+		{"PERMISSION_CREATE", "permission-create", "Permission required to create plops.", (*stringValue)(&c.Permissions.Create)},
+		{"PERMISSION_MODERATE", "permission-moderate", "Permission required to moderate plops.", (*stringValue)(&c.Permissions.Moderate)},
+
+		{"BACKUP_DIR", "backup-dir", "Directory of database snapshots. Scheduled backups are disabled if empty.", (*stringValue)(&c.Backup.Dir)},
+		{"BACKUP_INTERVAL", "backup-interval", "Time between scheduled database snapshots.", (*durationValue)(&c.Backup.Interval)},
+		{"BACKUP_KEEP", "backup-keep", "Number of the newest database snapshots that are kept.", (*intValue)(&c.Backup.Keep)},
+	}
+}
+
+// LoadConfig returns the configuration built from the environment, the
+// configuration file and command line arguments. Configuration flags are
+// registered in given flag set, which may already contain command specific
+// flags, before parsing given arguments. The configuration file path is
+// provided with the -config flag or the CONFIG environment variable. The
+// returned configuration is valid.
+//
+// If the -print-config flag is provided, printConfig is true.
+func LoadConfig(fs *flag.FlagSet, args []string) (conf Config, printConfig bool, err error) {
+	conf = DefaultConfig()
+	vars := conf.vars()
+
+	// A flag presents the value it holds when registered as its default,
+	// so flags must be registered before the environment is applied.
+	// Otherwise the help output would contain environment secrets.
+	configPath := fs.String("config", os.Getenv("CONFIG"), "TOML or YAML configuration file path.")
+	fs.BoolVar(&printConfig, "print-config", false, "Print the effective configuration with secrets redacted and exit.")
+	for _, v := range vars {
+		fs.Var(v.value, v.flag, v.usage+" Environment variable "+v.env+".")
+	}
+
+	for _, v := range vars {
+		if raw, ok := os.LookupEnv(v.env); ok {
+			if err := v.value.Set(raw); err != nil {
+				return conf, false, fmt.Errorf("invalid %s environment variable: %w", v.env, err)
+			}
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return conf, false, err
+	}
+
+	if *configPath != "" {
+		// Flags override the file, so they must be applied again once
+		// the file is loaded.
+		flags := make(map[string]string)
+		fs.Visit(func(f *flag.Flag) { flags[f.Name] = f.Value.String() })
+
+		if err := conf.loadFile(*configPath); err != nil {
+			return conf, false, err
+		}
+		for name, value := range flags {
+			if err := fs.Set(name, value); err != nil {
+				return conf, false, fmt.Errorf("invalid -%s flag: %w", name, err)
+			}
+		}
+	}
+
+	if err := conf.Validate(); err != nil {
+		return conf, false, err
+	}
+	return conf, printConfig, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read configuration file: %w", err)
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		md, err := toml.Decode(string(b), c)
+		if err != nil {
+			return fmt.Errorf("cannot decode %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) != 0 {
+			return fmt.Errorf("unknown %s configuration key %q", path, undecoded[0].String())
+		}
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(b)))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("cannot decode %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported configuration file format %q, must be .toml, .yaml or .yml", ext)
+	}
+	return nil
+}
+
+// Validate returns an error describing all invalid values.
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, name+": "+err.Error())
+		}
+	}
+
+	check("port", validatePort(c.Port))
+	if c.MetricsPort != "" {
+		check("metrics_port", validatePort(c.MetricsPort))
+		if c.MetricsPort == c.Port {
+			check("metrics_port", errors.New("must differ from port"))
+		}
+	}
+	check("lith_api", validateURL(c.LithAPI))
+	// Configuration printed by WriteTo cannot be used as is.
+	if c.Secret == redacted {
+		check("secret", errors.New("redacted value must be replaced"))
+	}
+	if u, err := url.Parse(c.LithAPI); err == nil && u.User != nil && u.User.Username() == redacted {
+		check("lith_api", errors.New("redacted credentials must be replaced"))
+	}
+	check("login_url", validateURL(c.LoginURL))
+	check("database", validateFilePath(c.Database))
+	if _, err := logging.ParseFormat(c.LogFormat); err != nil {
+		check("log_format", err)
+	}
+	if c.TraceExport != "" && c.TraceExport != "stdout" {
+		check("trace_export", validateFilePath(c.TraceExport))
+	}
+	check("http.read_timeout", validatePositive(c.HTTP.ReadTimeout))
+	check("http.read_header_timeout", validatePositive(c.HTTP.ReadHeaderTimeout))
+	check("http.write_timeout", validatePositive(c.HTTP.WriteTimeout))
+	check("http.idle_timeout", validatePositive(c.HTTP.IdleTimeout))
+	check("http.shutdown_timeout", validatePositive(c.HTTP.ShutdownTimeout))
+
+	if c.TemplateDir != "" {
+		check("template_dir", validateDir(c.TemplateDir))
+	}
+	if c.Backup.Dir != "" {
+		check("backup.dir", validateDir(c.Backup.Dir))
+	}
+	check("backup.interval", validatePositive(c.Backup.Interval))
+	if c.Backup.Keep < 1 {
+		check("backup.keep", fmt.Errorf("%d is not a positive number", c.Backup.Keep))
+	}
+
+	// Settings require a secret, which is generated if not provided.
+	settings := c.Settings()
+	settings.CSRFSecret = []byte("x")
+	check("limits and permissions", settings.Validate())
+
+	if len(errs) != 0 {
+		return fmt.Errorf("invalid configuration:\n\t%s", strings.Join(errs, "\n\t"))
+	}
+	return nil
+}
+
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q is not a valid port number", port)
+	}
+	return nil
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute HTTP URL", raw)
+	}
+	return nil
+}
+
+// validateFilePath returns an error if a file cannot be created under given
+// path, because its directory does not exist.
+func validateFilePath(path string) error {
+	if path == "" {
+		return errors.New("path is required")
+	}
+	return validateDir(filepath.Dir(path))
+}
+
+func validateDir(dir string) error {
+	switch fi, err := os.Stat(dir); {
+	case err != nil:
+		return fmt.Errorf("directory %q: %w", dir, err)
+	case !fi.IsDir():
+		return fmt.Errorf("%q is not a directory", dir)
+	}
+	return nil
+}
+
+func validatePositive(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%s is not a positive duration", d)
+	}
+	return nil
+}
+
+// Settings returns the HTTP application settings. CSRF secret is not set.
+func (c *Config) Settings() plopper.Settings {
+	return plopper.Settings{
+		AuthUI:             c.LoginURL,
+		PlopsPerPage:       c.Limits.PlopsPerPage,
+		MinContentLength:   c.Limits.MinContentLength,
+		MaxContentLength:   c.Limits.MaxContentLength,
+		MaxNewlines:        c.Limits.MaxNewlines,
+		CreatePermission:   c.Permissions.Create,
+		ModeratePermission: c.Permissions.Moderate,
+		CreateRateLimit:    c.Limits.CreateRateLimit,
+		CreateRateWindow:   c.Limits.CreateRateWindow,
+		TemplateDir:        c.TemplateDir,
+	}
+}
+
+// redacted replaces secrets in the configuration written by WriteTo.
+const redacted = "[redacted]"
+
+// WriteTo writes the configuration in the TOML format, with secrets
+// redacted. Validation of the written configuration fails until the
+// redacted values are replaced.
+func (c Config) WriteTo(w io.Writer) (int64, error) {
+	if c.Secret != "" {
+		c.Secret = redacted
+	}
+	if u, err := url.Parse(c.LithAPI); err == nil && u.User != nil {
+		u.User = url.User(redacted)
+		c.LithAPI = u.String()
+	}
+	cw := &countingWriter{w: w}
+	err := toml.NewEncoder(cw).Encode(c)
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+type stringValue string
+
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+// listValue is a comma separated list of values.
+type listValue []string
+
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+func (v *listValue) Set(s string) error {
+	*v = nil
+	for _, el := range strings.Split(s, ",") {
+		if el = strings.TrimSpace(el); el != "" {
+			*v = append(*v, el)
+		}
+	}
+	return nil
+}
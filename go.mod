@@ -2,4 +2,10 @@ module github.com/husio/plopper
 
 go 1.17
 
-require github.com/mattn/go-sqlite3 v1.14.11
+require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/mattn/go-sqlite3 v1.14.11
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/text v0.3.8
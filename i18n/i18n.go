@@ -0,0 +1,279 @@
+// Package i18n implements translation of user facing messages.
+//
+// Messages are identified by their English text, which is also used when a
+// translation is missing. Each locale is described by a JSON file in the
+// locales directory:
+//
+//	{
+//		"name": "Polski",
+//		"messages": {
+//			"Show newest plops": "Pokaż najnowsze plopy",
+//			"%d minute ago": {"one": "%d minutę temu", "few": "%d minuty temu", "many": "%d minut temu"}
+//		}
+//	}
+//
+// A message with plural forms is identified by its English singular form.
+// Required forms depend on the plural rule of the language.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Plural forms, named after the CLDR plural categories.
+const (
+	One   = "one"
+	Few   = "few"
+	Many  = "many"
+	Other = "other"
+)
+
+// pluralRules return the plural form that should be used for given count.
+// Every supported language must have a rule.
+var pluralRules = map[string]func(n int) string{
+	"en": func(n int) string {
+		if n == 1 {
+			return One
+		}
+		return Other
+	},
+	"pl": func(n int) string {
+		if n == 1 {
+			return One
+		}
+		if n < 0 {
+			n = -n
+		}
+		if d, dd := n%10, n%100; d >= 2 && d <= 4 && (dd < 12 || dd > 14) {
+			return Few
+		}
+		return Many
+	},
+}
+
+// Locale translates messages to a single language.
+type Locale struct {
+	// Tag is the BCP 47 language tag, for example "pl".
+	Tag string
+	// Name is the name of the language in that language.
+	Name string
+
+	plural   func(int) string
+	messages map[string]message
+}
+
+type message struct {
+	text  string
+	forms map[string]string
+}
+
+func (m *message) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &m.text); err == nil {
+		return nil
+	}
+	return json.Unmarshal(b, &m.forms)
+}
+
+// T returns the translation of given message, formatted with given
+// arguments.
+func (l *Locale) T(msg string, args ...interface{}) string {
+	if m, ok := l.messages[msg]; ok && m.text != "" {
+		msg = m.text
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// N returns the translation of the plural form of given message suitable for
+// count n, formatted with given arguments. If no arguments are given, n is
+// used. One and other are the English singular and plural forms.
+func (l *Locale) N(n int, one, other string, args ...interface{}) string {
+	if len(args) == 0 {
+		args = []interface{}{n}
+	}
+	msg := other
+	if n == 1 {
+		msg = one
+	}
+	if m, ok := l.messages[one]; ok {
+		if form, ok := m.forms[l.plural(n)]; ok {
+			msg = form
+		}
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+var (
+	//go:embed locales/*.json
+	localesFS embed.FS
+	locales   = mustLoadLocales(localesFS, "locales")
+
+	// English is the default locale. Messages are written in English, so
+	// it does not need translations.
+	English = locales["en"]
+)
+
+func mustLoadLocales(fsys fs.FS, dir string) map[string]*Locale {
+	ls, err := loadLocales(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return ls
+}
+
+func loadLocales(fsys fs.FS, dir string) (map[string]*Locale, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	ls := make(map[string]*Locale)
+	for _, e := range entries {
+		tag := strings.TrimSuffix(e.Name(), ".json")
+		plural, ok := pluralRules[tag]
+		if !ok {
+			return nil, fmt.Errorf("%s: no plural rule for %q", e.Name(), tag)
+		}
+		raw, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var content struct {
+			Name     string             `json:"name"`
+			Messages map[string]message `json:"messages"`
+		}
+		if err := json.Unmarshal(raw, &content); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		ls[tag] = &Locale{
+			Tag:      tag,
+			Name:     content.Name,
+			plural:   plural,
+			messages: content.Messages,
+		}
+	}
+	return ls, nil
+}
+
+// Lookup returns the locale of given language tag.
+func Lookup(tag string) (*Locale, bool) {
+	l, ok := locales[strings.ToLower(tag)]
+	return l, ok
+}
+
+// Locales returns all supported locales, ordered by tag.
+func Locales() []*Locale {
+	ls := make([]*Locale, 0, len(locales))
+	for _, l := range locales {
+		ls = append(ls, l)
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Tag < ls[j].Tag })
+	return ls
+}
+
+// Negotiate returns the supported locale most preferred by the client, as
+// described by the value of the Accept-Language header. English is returned
+// if none of the preferred languages is supported.
+func Negotiate(acceptLanguage string) *Locale {
+	var (
+		best  *Locale
+		bestQ float64
+	)
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, q := parseLanguageRange(part)
+		if q <= bestQ {
+			continue
+		}
+		// Only the primary language is relevant, because no regional
+		// variants are supported.
+		if i := strings.IndexByte(tag, '-'); i != -1 {
+			tag = tag[:i]
+		}
+		if l, ok := Lookup(tag); ok {
+			best, bestQ = l, q
+		}
+	}
+	if best == nil {
+		return English
+	}
+	return best
+}
+
+// parseLanguageRange parses a single element of the Accept-Language header,
+// for example "pl-PL;q=0.8". The quality is 0 if the element is invalid.
+func parseLanguageRange(s string) (tag string, q float64) {
+	chunks := strings.Split(s, ";")
+	tag = strings.TrimSpace(chunks[0])
+	q = 1
+	for _, param := range chunks[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+		v, err := strconv.ParseFloat(param[2:], 64)
+		if err != nil || v < 0 || v > 1 {
+			return tag, 0
+		}
+		q = v
+	}
+	return tag, q
+}
+
+// PluralForms returns the plural forms used by the language of the locale.
+func (l *Locale) PluralForms() []string {
+	seen := make(map[string]bool)
+	var forms []string
+	for n := 0; n < 200; n++ {
+		if f := l.plural(n); !seen[f] {
+			seen[f] = true
+			forms = append(forms, f)
+		}
+	}
+	sort.Strings(forms)
+	return forms
+}
+
+// HasMessage returns true if the locale translates given message. For a
+// message with plural forms, all forms of the language must be provided.
+func (l *Locale) HasMessage(msg string, plural bool) bool {
+	m, ok := l.messages[msg]
+	if !ok {
+		return false
+	}
+	if !plural {
+		return m.text != ""
+	}
+	for _, f := range l.PluralForms() {
+		if _, ok := m.forms[f]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+type contextKey int
+
+const localeContextKey contextKey = iota
+
+// NewContext returns a context carrying given locale.
+func NewContext(ctx context.Context, l *Locale) context.Context {
+	return context.WithValue(ctx, localeContextKey, l)
+}
+
+// FromContext returns the locale carried by the context, or English if there
+// is none.
+func FromContext(ctx context.Context) *Locale {
+	if l, ok := ctx.Value(localeContextKey).(*Locale); ok {
+		return l
+	}
+	return English
+}
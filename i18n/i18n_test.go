@@ -0,0 +1,106 @@
+package i18n
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPluralRules(t *testing.T) {
+	cases := map[string]map[int]string{
+		"en": {0: Other, 1: One, 2: Other, 5: Other, 21: Other},
+		"pl": {0: Many, 1: One, 2: Few, 4: Few, 5: Many, 12: Many, 14: Many, 21: Many, 22: Few, 25: Many, 112: Many, 122: Few},
+	}
+	for tag, want := range cases {
+		l, ok := Lookup(tag)
+		if !ok {
+			t.Fatalf("%s: locale not found", tag)
+		}
+		for n, form := range want {
+			if got := l.plural(n); got != form {
+				t.Errorf("%s: want %q form for %d, got %q", tag, form, n, got)
+			}
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                        "en",
+		"*":                       "en",
+		"de":                      "en",
+		"pl":                      "pl",
+		"PL":                      "pl",
+		"pl-PL":                   "pl",
+		"pl-PL,pl;q=0.9,en;q=0.8": "pl",
+		"en-US,en;q=0.9,pl;q=0.8": "en",
+		"de,pl;q=0.5":             "pl",
+		"en;q=0.5, pl;q=0.7":      "pl",
+		"pl;q=0,en;q=0.1":         "en",
+		"pl;q=invalid":            "en",
+	}
+	for header, want := range cases {
+		if got := Negotiate(header).Tag; got != want {
+			t.Errorf("%q: want %q, got %q", header, want, got)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	l := &Locale{
+		Tag:    "pl",
+		plural: pluralRules["pl"],
+		messages: map[string]message{
+			"Hello %s": {text: "Cześć %s"},
+			"%d plop":  {forms: map[string]string{One: "%d plop", Few: "%d plopy", Many: "%d plopów"}},
+		},
+	}
+
+	if got := l.T("Hello %s", "Bob"); got != "Cześć Bob" {
+		t.Errorf("translated: got %q", got)
+	}
+	if got := l.T("Goodbye %s", "Bob"); got != "Goodbye Bob" {
+		t.Errorf("missing translation must fall back to the message: got %q", got)
+	}
+	if got := l.T("100%"); got != "100%" {
+		t.Errorf("message without arguments must not be formatted: got %q", got)
+	}
+
+	plurals := map[int]string{1: "1 plop", 3: "3 plopy", 5: "5 plopów", 22: "22 plopy"}
+	for n, want := range plurals {
+		if got := l.N(n, "%d plop", "%d plops"); got != want {
+			t.Errorf("%d: want %q, got %q", n, want, got)
+		}
+	}
+	if got := l.N(3, "%d comment", "%d comments"); got != "3 comments" {
+		t.Errorf("missing plural must fall back to English: got %q", got)
+	}
+	if got := l.N(3, "%[2]s has %[1]d plop", "%[2]s has %[1]d plops", 3, "Bob"); got != "Bob has 3 plops" {
+		t.Errorf("explicit arguments: got %q", got)
+	}
+}
+
+func TestLocalesComplete(t *testing.T) {
+	for _, l := range Locales() {
+		if l.Name == "" {
+			t.Errorf("%s: no name", l.Tag)
+		}
+		for msg, m := range l.messages {
+			if m.text == "" && len(m.forms) == 0 {
+				t.Errorf("%s: empty translation of %q", l.Tag, msg)
+			}
+			if len(m.forms) != 0 && !l.HasMessage(msg, true) {
+				t.Errorf("%s: %q does not provide all of %v forms", l.Tag, msg, l.PluralForms())
+			}
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != English {
+		t.Fatalf("want English by default, got %q", got.Tag)
+	}
+	pl, _ := Lookup("pl")
+	if got := FromContext(NewContext(context.Background(), pl)); got != pl {
+		t.Fatalf("want pl, got %q", got.Tag)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -147,6 +148,48 @@ type AccountSession struct {
 	Permissions []string `json:"permissions"`
 }
 
+// Accounts returns public details of accounts with given IDs. Accounts that do
+// not exist are not returned.
+func (c Client) Accounts(ctx context.Context, accountIDs []string) ([]*Account, error) {
+	query := url.Values{"id": accountIDs}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL+"/accounts?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("new HTTP request: %w", err)
+	}
+
+	resp, err := c.httpcli.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// All good.
+	default:
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1e5))
+		return nil, fmt.Errorf("unexpected response %d: %s", resp.StatusCode, string(b))
+	}
+
+	var payload struct {
+		Accounts []*Account `json:"accounts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return payload.Accounts, nil
+}
+
+// Account contains public details of an account.
+type Account struct {
+	AccountID string `json:"account_id"`
+	// DisplayName is the name chosen by the account owner. It can be
+	// empty.
+	DisplayName string `json:"display_name"`
+	// AvatarURL is the address of the account image. It can be empty.
+	AvatarURL string `json:"avatar_url"`
+}
+
 // TwoFactor returns true if two-factor authentication is enabled for the
 // account referenced by session with given token.
 func (c Client) TwoFactor(ctx context.Context, token string) (bool, error) {
@@ -226,6 +269,28 @@ func (c Client) TwoFactorEnable(ctx context.Context, token, secret, code string)
 	}
 }
 
+// Ping returns an error if the authentication service cannot be reached or
+// is not able to serve requests.
+func (c Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL+"/sessions", nil)
+	if err != nil {
+		return fmt.Errorf("new HTTP request: %w", err)
+	}
+
+	resp, err := c.httpcli.Do(req)
+	if err != nil {
+		return fmt.Errorf("do HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Any response other than a server error means that the service is
+	// up. Unauthorized is expected, because no session token is provided.
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("unexpected response %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // AuthMiddleware return an http.Handler middleware introspects each incoming
 // request and if authentication information is provided, verifies it and
 // includes session information in the context.
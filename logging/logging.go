@@ -0,0 +1,234 @@
+// Package logging implements a structured logger that writes entries in
+// either logfmt or JSON format.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Format describes how log entries are serialized.
+type Format string
+
+const (
+	Logfmt Format = "logfmt"
+	JSON   Format = "json"
+)
+
+// ParseFormat returns the format with given name.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case Logfmt, JSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown log format %q", name)
+}
+
+// Logger writes structured log entries. Each entry consists of the time, the
+// level, the message and any number of key-value pairs.
+//
+// Logger is safe for concurrent use.
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	format Format
+	// keyvals are included in every entry written by this logger.
+	keyvals []interface{}
+}
+
+// New returns a logger writing entries to out using given format.
+func New(out io.Writer, format Format) *Logger {
+	return &Logger{
+		mu:     &sync.Mutex{},
+		out:    out,
+		format: format,
+	}
+}
+
+// Default is the logger returned by FromContext if the context does not
+// carry any logger.
+var Default = New(os.Stderr, Logfmt)
+
+// With returns a logger that includes given key-value pairs in every entry.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	return &Logger{
+		mu:      l.mu,
+		out:     l.out,
+		format:  l.format,
+		keyvals: append(append([]interface{}(nil), l.keyvals...), keyvals...),
+	}
+}
+
+// Info writes an informational entry.
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log("info", msg, keyvals)
+}
+
+// Error writes an entry describing a failure.
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log("error", msg, keyvals)
+}
+
+func (l *Logger) log(level, msg string, keyvals []interface{}) {
+	all := make([]interface{}, 0, 6+len(l.keyvals)+len(keyvals))
+	all = append(all, "time", time.Now().UTC().Format(time.RFC3339Nano), "level", level, "msg", msg)
+	all = append(all, l.keyvals...)
+	all = append(all, keyvals...)
+	if len(all)%2 != 0 {
+		all = append(all, "(MISSING)")
+	}
+
+	var b bytes.Buffer
+	switch l.format {
+	case JSON:
+		writeJSON(&b, all)
+	default:
+		writeLogfmt(&b, all)
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	_, _ = l.out.Write(b.Bytes())
+	l.mu.Unlock()
+}
+
+func writeLogfmt(b *bytes.Buffer, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		if i != 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(logfmtKey(fmt.Sprint(keyvals[i])))
+		b.WriteByte('=')
+		v := stringify(keyvals[i+1])
+		if v == "" || strings.ContainsAny(v, " =\"\\") || !isPrintable(v) {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(v)
+	}
+}
+
+func logfmtKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+func isPrintable(s string) bool {
+	for _, r := range s {
+		if r < ' ' || r == 0x7f || r == utf8.RuneError {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(b *bytes.Buffer, keyvals []interface{}) {
+	b.WriteByte('{')
+	for i := 0; i < len(keyvals); i += 2 {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(fmt.Sprint(keyvals[i]))
+		b.Write(k)
+		b.WriteByte(':')
+		var v []byte
+		switch val := keyvals[i+1].(type) {
+		case int, int64, float64, bool:
+			v, _ = json.Marshal(val)
+		default:
+			v, _ = json.Marshal(stringify(val))
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+}
+
+func stringify(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Writer returns an io.Writer that writes each line as a separate info
+// entry. It allows to redirect output of the standard library log package.
+func (l *Logger) Writer() io.Writer {
+	return lineWriter{l: l}
+}
+
+type lineWriter struct {
+	l *Logger
+}
+
+func (w lineWriter) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		w.l.Info(line)
+	}
+	return len(b), nil
+}
+
+// NewContext returns a context carrying given logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, l)
+}
+
+// FromContext returns the logger carried by the context, or Default.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerContextKey).(*Logger); ok {
+		return l
+	}
+	return Default
+}
+
+// Infof writes an informational entry using the logger carried by the
+// context. Message is formatted according to the format specifier.
+func Infof(ctx context.Context, format string, args ...interface{}) {
+	FromContext(ctx).Info(fmt.Sprintf(format, args...))
+}
+
+// Errorf writes an entry describing a failure using the logger carried by
+// the context. Message is formatted according to the format specifier.
+func Errorf(ctx context.Context, format string, args ...interface{}) {
+	FromContext(ctx).Error(fmt.Sprintf(format, args...))
+}
+
+// WithRequestID returns a context carrying the ID of the request that is
+// being processed.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// RequestID returns the ID of the processed request or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+type contextKey int
+
+const (
+	loggerContextKey contextKey = iota
+	requestIDContextKey
+)
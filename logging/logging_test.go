@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogfmt(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, Logfmt).With("request_id", "abc")
+	l.Info("request", "path", "/plop/1", "status", 200, "duration", 1500*time.Millisecond, "account_id", "")
+	l.Error("cannot list", "err", errors.New(`bad "thing"`), "odd")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 lines, got %q", b.String())
+	}
+	// Skip the time field.
+	want := []string{
+		`level=info msg=request request_id=abc path=/plop/1 status=200 duration=1.5s account_id=""`,
+		`level=error msg="cannot list" request_id=abc err="bad \"thing\"" odd=(MISSING)`,
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "time=") {
+			t.Fatalf("line %d: time missing: %s", i, line)
+		}
+		if got := line[strings.Index(line, " ")+1:]; got != want[i] {
+			t.Errorf("line %d:\nwant %s\n got %s", i, want[i], got)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var b bytes.Buffer
+	New(&b, JSON).Info("multi\nline", "status", 404, "ok", true)
+
+	line := b.String()
+	want := `"level":"info","msg":"multi\nline","status":404,"ok":true}` + "\n"
+	if !strings.HasPrefix(line, `{"time":`) || !strings.HasSuffix(line, want) {
+		t.Fatalf("unexpected entry: %s", line)
+	}
+}
+
+func TestContext(t *testing.T) {
+	var b bytes.Buffer
+	ctx := NewContext(context.Background(), New(&b, Logfmt).With("request_id", "xyz"))
+	ctx = WithRequestID(ctx, "xyz")
+
+	Errorf(ctx, "cannot get plop %d", 42)
+	if !strings.Contains(b.String(), `msg="cannot get plop 42" request_id=xyz`) {
+		t.Fatalf("unexpected entry: %s", b.String())
+	}
+	if id := RequestID(ctx); id != "xyz" {
+		t.Fatalf("want xyz request ID, got %q", id)
+	}
+	if l := FromContext(context.Background()); l != Default {
+		t.Fatal("want default logger for a context without logger")
+	}
+}
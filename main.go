@@ -1,60 +1,256 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/husio/plopper/lith"
+	"github.com/husio/plopper/logging"
+	"github.com/husio/plopper/metrics"
 	"github.com/husio/plopper/plopper"
+	"github.com/husio/plopper/tracing"
 )
 
 func main() {
-	conf := struct {
-		Port     string
-		AuthAPI  string
-		AuthUI   string
-		Database string
-	}{
-		Port:     env("PORT", "8000"),
-		AuthAPI:  env("LITH_API", "https://lith-demo.herokuapp.com/api"),
-		AuthUI:   env("LOGIN_URL", "https://lith-demo.herokuapp.com/pub/"),
-		Database: env("DATABASE", "/tmp/plopper.sqlite3"),
+	name, args := "serve", os.Args[1:]
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(os.Stdout)
+		return
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("plopper "+cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s\n\n", strings.TrimSpace("plopper "+cmd.name+" [flags] "+cmd.args), cmd.summary)
+		fs.PrintDefaults()
+	}
+	execute := cmd.setup(fs)
+	conf, printConfig, err := LoadConfig(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if printConfig {
+		if _, err := conf.WriteTo(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Format was validated when loading the configuration.
+	format, _ := logging.ParseFormat(conf.LogFormat)
+	logging.Default = logging.New(os.Stderr, format)
+	// Anything written using the log package is a structured entry as well.
+	log.SetFlags(0)
+	log.SetOutput(logging.Default.Writer())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := execute(ctx, conf, fs.Args()); err != nil {
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			os.Exit(2)
+		}
+		logging.Default.Error(err.Error())
+		stop()
+		os.Exit(1)
+	}
+}
+
+// runServe runs the HTTP server until given context is cancelled.
+func runServe(ctx context.Context, conf Config, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	csrfSecret := []byte(conf.Secret)
+	if len(csrfSecret) == 0 {
+		log.Printf("SECRET not set, using a random one. CSRF tokens will not survive a restart.")
+		csrfSecret = make([]byte, 32)
+		if _, err := rand.Read(csrfSecret); err != nil {
+			return fmt.Errorf("cannot generate secret: %w", err)
+		}
 	}
 
-	log.SetOutput(os.Stderr)
-	log.Printf("Running HTTP server on port %s", conf.Port)
+	switch conf.TraceExport {
+	case "":
+	case "stdout":
+		tracing.Default = tracing.NewTracer(tracing.NewJSONExporter(os.Stdout))
+	default:
+		fd, err := os.OpenFile(conf.TraceExport, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("cannot open trace export file: %w", err)
+		}
+		defer fd.Close()
+		tracing.Default = tracing.NewTracer(tracing.NewJSONExporter(fd))
+	}
 
-	auth := lith.NewClient(conf.AuthAPI, &http.Client{Transport: requestLogger{}})
+	auth := lith.NewClient(conf.LithAPI, &http.Client{
+		Transport: tracing.Transport(tracing.Default, requestLogger{}),
+		// Lith is asked while serving requests, so an outage must not
+		// hold them for longer than this.
+		Timeout: 5 * time.Second,
+	})
 
-	plopStore, err := plopper.OpenSQLitePlopStore(conf.Database)
+	store, err := openStore(conf)
 	if err != nil {
-		log.Fatalf("cannot open plops store: %s", err)
+		return err
 	}
-	defer plopStore.Close()
+	plopStore := plopper.InstrumentPlopStore(store)
+	defer func() {
+		if err := plopStore.Close(); err != nil {
+			log.Printf("cannot close plops store: %s", err)
+		}
+	}()
 
-	http.Handle("/", plopper.NewHTTPApplication(plopStore, auth, conf.AuthUI))
+	// Background workers must return once ctx is cancelled. All of them
+	// are awaited before the store is closed.
+	var workers sync.WaitGroup
+	defer func() {
+		stop()
+		workers.Wait()
+	}()
 
-	if err := http.ListenAndServe(":"+conf.Port, nil); err != nil {
-		log.Fatalf("http server: %s", err)
+	if conf.Backup.Dir != "" {
+		snapshotter, ok := store.(plopper.Snapshotter)
+		if !ok {
+			return errors.New("store does not support backups")
+		}
+		backups := &plopper.Backups{Store: snapshotter, Dir: conf.Backup.Dir, Keep: conf.Backup.Keep}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			backups.Run(ctx, conf.Backup.Interval)
+		}()
+	}
+
+	moderator := plopper.ModerationChain{
+		plopper.BlockedMentionFilter(plopStore),
+		plopper.DuplicateFilter(plopStore, time.Hour),
+		plopper.LinkCountFilter(3, plopper.PlopHeld),
+		plopper.WordListFilter(conf.ModerationWords, plopper.PlopHeld),
+		plopper.LogModerator{},
+	}
+
+	settings := conf.Settings()
+	settings.CSRFSecret = csrfSecret
+	http.Handle("/", plopper.NewHTTPApplication(plopStore, auth, moderator, settings))
+
+	server := &http.Server{
+		Addr:              ":" + conf.Port,
+		Handler:           tracing.Middleware(tracing.Default)(plopper.AccessLogMiddleware(logging.Default)(http.DefaultServeMux)),
+		ReadTimeout:       conf.HTTP.ReadTimeout,
+		ReadHeaderTimeout: conf.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      conf.HTTP.WriteTimeout,
+		IdleTimeout:       conf.HTTP.IdleTimeout,
+	}
+	serverErr := make(chan error, 2)
+	go func() {
+		log.Printf("Running HTTP server on port %s", conf.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// Metrics are served on a separate port, which is not meant to be
+	// exposed publicly.
+	var metricsServer *http.Server
+	if conf.MetricsPort != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:              ":" + conf.MetricsPort,
+			Handler:           mux,
+			ReadHeaderTimeout: conf.HTTP.ReadHeaderTimeout,
+			WriteTimeout:      conf.HTTP.WriteTimeout,
+		}
+		go func() {
+			log.Printf("Serving metrics on port %s", conf.MetricsPort)
+			serverErr <- metricsServer.ListenAndServe()
+		}()
 	}
-}
 
-func env(name, fallback string) string {
-	if v, ok := os.LookupEnv(name); ok {
-		return v
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("http server: %w", err)
+	case <-ctx.Done():
 	}
-	return fallback
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests.", conf.HTTP.ShutdownTimeout)
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.HTTP.ShutdownTimeout)
+	defer cancel()
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("metrics server shutdown: %s", err)
+		}
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("http server shutdown: %w", err)
+	}
+	return nil
 }
 
+var (
+	lithRequests = metrics.NewCounter(
+		"plopper_lith_requests_total",
+		"Number of requests made to the lith API.",
+		"method", "outcome")
+	lithRequestDuration = metrics.NewHistogram(
+		"plopper_lith_request_duration_seconds",
+		"Duration of requests made to the lith API.",
+		nil, "method")
+)
+
+// requestLogger logs and measures all requests made to lith. The ID of the
+// request being served is passed along in the X-Request-ID header.
 type requestLogger struct{}
 
 func (requestLogger) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if id := logging.RequestID(ctx); id != "" {
+		// RoundTripper must not modify the original request.
+		req = req.Clone(ctx)
+		req.Header.Set("X-Request-ID", id)
+	}
+
+	start := time.Now()
 	resp, err := http.DefaultTransport.RoundTrip(req)
+	duration := time.Since(start)
+	lithRequestDuration.Observe(duration.Seconds(), req.Method)
+
+	logger := logging.FromContext(ctx)
 	if err != nil {
-		log.Printf("request to %q results in error %s", req.URL, err)
+		lithRequests.Inc(req.Method, "error")
+		logger.Error("lith request failed", "method", req.Method, "url", req.URL, "duration", duration, "err", err)
 	} else {
-		log.Printf("request to %q results in status code %d", req.URL, resp.StatusCode)
+		lithRequests.Inc(req.Method, strconv.Itoa(resp.StatusCode))
+		logger.Info("lith request", "method", req.Method, "url", req.URL, "duration", duration, "status", resp.StatusCode)
 	}
 	return resp, err
 }
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounter("requests_total", "Number of requests.", "handler", "code")
+	c.Inc("list", "200")
+	c.Inc("list", "200")
+	c.Add(3, "show", `4"4`)
+
+	h := r.NewHistogram("duration_seconds", "Duration.", []float64{0.1, 1}, "handler")
+	h.Observe(0.05, "list")
+	h.Observe(0.5, "list")
+	h.Observe(5, "list")
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+
+	want := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{handler="list",code="200"} 2
+requests_total{handler="show",code="4\"4"} 3
+# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{handler="list",le="0.1"} 1
+duration_seconds_bucket{handler="list",le="1"} 2
+duration_seconds_bucket{handler="list",le="+Inf"} 3
+duration_seconds_sum{handler="list"} 5.55
+duration_seconds_count{handler="list"} 3
+`
+	if got := b.String(); got != want {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
@@ -0,0 +1,110 @@
+package plopper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/husio/plopper/lith"
+	"github.com/husio/plopper/logging"
+	"github.com/husio/plopper/tracing"
+)
+
+// AccessLogMiddleware returns an http.Handler middleware that assigns an ID to
+// each request and writes an access log entry once the request is served.
+//
+// Request ID is taken from the X-Request-ID header if provided, otherwise a
+// new one is generated. It is returned in the X-Request-ID response header.
+// Within decorated http.Handler, logging.FromContext returns a logger that
+// includes the request ID in every entry. If the request is traced, the trace
+// ID is included as well.
+func AccessLogMiddleware(logger *logging.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &accessLogMiddleware{
+			logger: logger,
+			next:   next,
+		}
+	}
+}
+
+type accessLogMiddleware struct {
+	logger *logging.Logger
+	next   http.Handler
+}
+
+func (m *accessLogMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	requestID := r.Header.Get("X-Request-ID")
+	if !isValidRequestID(requestID) {
+		requestID = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+
+	logger := m.logger.With("request_id", requestID)
+	if sc, ok := tracing.FromContext(r.Context()); ok {
+		logger = logger.With("trace_id", sc.TraceID)
+	}
+	entry := &accessLogEntry{}
+	ctx := logging.WithRequestID(r.Context(), requestID)
+	ctx = logging.NewContext(ctx, logger)
+	ctx = context.WithValue(ctx, accessLogEntryContextKey, entry)
+
+	rec := &statusRecorder{ResponseWriter: w}
+	m.next.ServeHTTP(rec, r.WithContext(ctx))
+
+	logger.Info("request",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", rec.Status(),
+		"duration", time.Since(start),
+		"account_id", entry.accountID)
+}
+
+// accessLogEntry collects information that is known only to the inner
+// handlers.
+type accessLogEntry struct {
+	accountID string
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// recordAccountMiddleware includes the ID of the authenticated account in the
+// access log and in all log entries of the request. It must be wrapped by
+// lith.AuthMiddleware.
+type recordAccountMiddleware struct {
+	next http.Handler
+}
+
+func (m *recordAccountMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if account, ok := lith.CurrentAccount(r.Context()); ok {
+		if entry, ok := r.Context().Value(accessLogEntryContextKey).(*accessLogEntry); ok {
+			entry.accountID = account.AccountID
+		}
+		logger := logging.FromContext(r.Context()).With("account_id", account.AccountID)
+		r = r.WithContext(logging.NewContext(r.Context(), logger))
+	}
+	m.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,303 @@
+package plopper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/husio/plopper/logging"
+	"github.com/husio/plopper/metrics"
+)
+
+var backupsTaken = metrics.NewCounter(
+	"plopper_backups_total",
+	"Number of database snapshots taken.",
+	"outcome")
+
+// Snapshotter is implemented by stores that can write a consistent copy of
+// their content into a file while being used.
+type Snapshotter interface {
+	// Snapshot writes a copy of the database to given path. The file must
+	// not exist.
+	Snapshot(ctx context.Context, path string) error
+}
+
+func (s *sqlPlopStore) Snapshot(ctx context.Context, path string) error {
+	// VACUUM INTO only reads the source database, but it is rejected by
+	// the read only pool. A separate connection is used so that the writer
+	// is not blocked for the duration of the copy. In WAL mode readers and
+	// the writer proceed while the snapshot is taken.
+	db := s.db
+	if s.path != "" {
+		conn, err := sql.Open("sqlite3", s.path+"?"+sqliteBusyTimeoutParam)
+		if err != nil {
+			return fmt.Errorf("cannot open SQLite database: %w", err)
+		}
+		defer conn.Close()
+		db = conn
+	}
+	if _, err := db.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("cannot vacuum into %s: %w", path, err)
+	}
+	return nil
+}
+
+// VerifySnapshot returns an error if given SQLite database file is corrupted.
+func VerifySnapshot(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", sqliteURI(path, "mode=ro"))
+	if err != nil {
+		return fmt.Errorf("cannot open snapshot: %w", err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return fmt.Errorf("cannot check integrity: %w", err)
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return fmt.Errorf("cannot scan integrity check: %w", err)
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot check integrity: %w", err)
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("snapshot %s is corrupted: %s", path, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// sqliteURI returns the URI filename of given database file, with given
+// query parameters. The path is escaped, so that it is never mistaken for
+// parameters.
+func sqliteURI(path, query string) string {
+	u := url.URL{Scheme: "file", Path: path, RawQuery: query}
+	return u.String()
+}
+
+// Backups maintains a rotating set of verified snapshots in a directory.
+type Backups struct {
+	Store Snapshotter
+	// Dir is the directory where snapshots are kept.
+	Dir string
+	// Keep is the number of the newest snapshots that are kept. Older
+	// snapshots are removed.
+	Keep int
+
+	// now returns the current time. time.Now is used if nil.
+	now func() time.Time
+}
+
+const (
+	snapshotPrefix  = "plopper-"
+	snapshotSuffix  = ".sqlite3"
+	snapshotTimeFmt = "20060102T150405.000000000Z"
+)
+
+// Run takes a snapshot every interval until given context is cancelled.
+// Failures are logged.
+func (b *Backups) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if path, err := b.Snapshot(ctx); err != nil {
+			logging.Errorf(ctx, "cannot take a snapshot: %s", err)
+		} else {
+			logging.Infof(ctx, "snapshot %s taken", path)
+		}
+	}
+}
+
+// Snapshot takes a new snapshot, verifies it and removes snapshots exceeding
+// the Keep limit. It returns the path of the new snapshot.
+func (b *Backups) Snapshot(ctx context.Context) (string, error) {
+	now := time.Now
+	if b.now != nil {
+		now = b.now
+	}
+	name := snapshotPrefix + now().UTC().Format(snapshotTimeFmt) + snapshotSuffix
+	path := filepath.Join(b.Dir, name)
+	// An incomplete snapshot must never look like a valid one.
+	tmp := filepath.Join(b.Dir, "."+name+".tmp")
+
+	if err := b.Store.Snapshot(ctx, tmp); err != nil {
+		_ = os.Remove(tmp)
+		backupsTaken.Inc("error")
+		return "", err
+	}
+	if err := VerifySnapshot(ctx, tmp); err != nil {
+		_ = os.Remove(tmp)
+		backupsTaken.Inc("error")
+		return "", err
+	}
+	// Unlike rename, link never replaces an existing snapshot.
+	err := os.Link(tmp, path)
+	_ = os.Remove(tmp)
+	if err != nil {
+		backupsTaken.Inc("error")
+		if errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("snapshot %s already exists", path)
+		}
+		return "", fmt.Errorf("cannot move snapshot: %w", err)
+	}
+	backupsTaken.Inc("ok")
+
+	if err := b.rotate(); err != nil {
+		return path, fmt.Errorf("cannot remove old snapshots: %w", err)
+	}
+	return path, nil
+}
+
+func (b *Backups) rotate() error {
+	snapshots, err := ListSnapshots(b.Dir)
+	if err != nil {
+		return err
+	}
+	if b.Keep < 1 || len(snapshots) <= b.Keep {
+		return nil
+	}
+	for _, path := range snapshots[:len(snapshots)-b.Keep] {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSnapshots returns paths of all snapshots in given directory, oldest
+// first.
+func ListSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if name := e.Name(); !e.IsDir() && strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, snapshotSuffix) {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	// Names contain the creation time, so they sort chronologically.
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// ErrDatabaseInUse is returned when the database cannot be restored because
+// it is used by another process, for example a running server.
+var ErrDatabaseInUse = errors.New("database is in use")
+
+// RestoreSnapshot verifies given snapshot and replaces the database file with
+// it. ErrDatabaseInUse is returned if any other connection uses the database.
+func RestoreSnapshot(ctx context.Context, snapshot, database string) error {
+	if err := VerifySnapshot(ctx, snapshot); err != nil {
+		return err
+	}
+	if err := checkDatabaseUnused(ctx, database); err != nil {
+		return err
+	}
+
+	src, err := os.Open(snapshot)
+	if err != nil {
+		return fmt.Errorf("cannot open snapshot: %w", err)
+	}
+	defer src.Close()
+
+	tmp := database + ".restore"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot create database file: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("cannot copy snapshot: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("cannot sync database file: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("cannot close database file: %w", err)
+	}
+
+	// Journal files of the replaced database must not be applied to the
+	// restored one.
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(database + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("cannot remove %s file: %w", suffix, err)
+		}
+	}
+	if err := os.Rename(tmp, database); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("cannot replace database file: %w", err)
+	}
+	return nil
+}
+
+// checkDatabaseUnused returns ErrDatabaseInUse if any other connection has
+// given database open. A connection in WAL mode holds a shared lock for as
+// long as it is open, so an exclusive lock can be taken only if there are no
+// other connections.
+//
+// The lock is released before returning, so that closing the connection does
+// not write to the database file once it is replaced.
+func checkDatabaseUnused(ctx context.Context, database string) error {
+	if _, err := os.Stat(database); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	db, err := sql.Open("sqlite3", sqliteURI(database, "mode=rw&_busy_timeout=0"))
+	if err != nil {
+		return fmt.Errorf("cannot open database: %w", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot open database: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `PRAGMA locking_mode = EXCLUSIVE`)
+	if err == nil {
+		_, err = conn.ExecContext(ctx, `BEGIN EXCLUSIVE`)
+	}
+	var sqliteErr sqlite3.Error
+	switch {
+	case err == nil:
+		_, err = conn.ExecContext(ctx, `ROLLBACK`)
+		return err
+	case errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked):
+		return ErrDatabaseInUse
+	default:
+		// A database that cannot be read cannot be used by a server
+		// either. Replacing a broken database is what restore is for.
+		return nil
+	}
+}
@@ -0,0 +1,152 @@
+package plopper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "plopper.sqlite3")
+	snapshotsDir := filepath.Join(dir, "snapshots")
+	if err := os.Mkdir(snapshotsDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenSQLitePlopStore(dbPath)
+	if err != nil {
+		t.Fatalf("cannot open store: %s", err)
+	}
+	keptID, err := store.Create(ctx, "alice", "kept", PlopPublished)
+	if err != nil {
+		t.Fatalf("cannot create plop: %s", err)
+	}
+
+	backups := &Backups{Store: store.(Snapshotter), Dir: snapshotsDir, Keep: 2}
+	var taken []string
+	for i := 0; i < 3; i++ {
+		path, err := backups.Snapshot(ctx)
+		if err != nil {
+			t.Fatalf("cannot take snapshot: %s", err)
+		}
+		taken = append(taken, path)
+	}
+	snapshots, err := ListSnapshots(snapshotsDir)
+	if err != nil {
+		t.Fatalf("cannot list snapshots: %s", err)
+	}
+	if len(snapshots) != 2 || snapshots[0] != taken[1] || snapshots[1] != taken[2] {
+		t.Fatalf("want two newest snapshots kept, got %q", snapshots)
+	}
+
+	lostID, err := store.Create(ctx, "alice", "lost", PlopPublished)
+	if err != nil {
+		t.Fatalf("cannot create plop: %s", err)
+	}
+	if err := RestoreSnapshot(ctx, snapshots[1], dbPath); !errors.Is(err, ErrDatabaseInUse) {
+		t.Fatalf("want database in use error while the store is open, got %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("cannot close store: %s", err)
+	}
+
+	if err := RestoreSnapshot(ctx, snapshots[1], dbPath); err != nil {
+		t.Fatalf("cannot restore: %s", err)
+	}
+	store, err = OpenSQLitePlopStore(dbPath)
+	if err != nil {
+		t.Fatalf("cannot open restored store: %s", err)
+	}
+	defer store.Close()
+	if _, err := store.Plop(ctx, keptID); err != nil {
+		t.Fatalf("want plop from the snapshot, got %v", err)
+	}
+	if _, err := store.Plop(ctx, lostID); err != ErrNotFound {
+		t.Fatalf("want plop created after the snapshot missing, got %v", err)
+	}
+}
+
+func TestRestoreRejectsCorruptedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "plopper-corrupted.sqlite3")
+	if err := os.WriteFile(snapshot, []byte("not a database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(dir, "plopper.sqlite3")
+	if err := os.WriteFile(dbPath, []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RestoreSnapshot(context.Background(), snapshot, dbPath); err == nil {
+		t.Fatal("corrupted snapshot restored")
+	}
+	if b, err := os.ReadFile(dbPath); err != nil || string(b) != "original" {
+		t.Fatalf("database file modified: %q, %v", b, err)
+	}
+}
+
+func TestSnapshotNeverReplacesExistingOne(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLitePlopStore(filepath.Join(t.TempDir(), "plopper.sqlite3"))
+	if err != nil {
+		t.Fatalf("cannot open store: %s", err)
+	}
+	defer store.Close()
+
+	dir := t.TempDir()
+	now := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	backups := &Backups{Store: store.(Snapshotter), Dir: dir, now: func() time.Time { return now }}
+	path, err := backups.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("cannot take snapshot: %s", err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Create(ctx, "alice", "changed", PlopPublished); err != nil {
+		t.Fatalf("cannot create plop: %s", err)
+	}
+	if _, err := backups.Snapshot(ctx); err == nil {
+		t.Fatal("snapshot with the same name taken")
+	}
+	if after, err := os.ReadFile(path); err != nil || !bytes.Equal(before, after) {
+		t.Fatalf("existing snapshot modified: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("want only the first snapshot, got %d files", len(entries))
+	}
+}
+
+func TestVerifySnapshotEscapesPath(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "a?mode=memory#b%20")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open store: %s", err)
+	}
+	defer store.Close()
+	path := filepath.Join(dir, "plopper.sqlite3")
+	if err := store.(Snapshotter).Snapshot(ctx, path); err != nil {
+		t.Fatalf("cannot take snapshot: %s", err)
+	}
+	if err := VerifySnapshot(ctx, path); err != nil {
+		t.Fatalf("cannot verify: %s", err)
+	}
+	if err := os.WriteFile(path, []byte("not a database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySnapshot(ctx, path); err == nil {
+		t.Fatal("corrupted snapshot verified")
+	}
+}
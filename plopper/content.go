@@ -0,0 +1,282 @@
+package plopper
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/husio/plopper/i18n"
+)
+
+// contentLimits describe what plop content is accepted. They are provided to
+// the create plop form, so that the browser can tell about a problem before
+// the plop is submitted.
+type contentLimits struct {
+	// MinLength and MaxLength limit the length of the normalized content,
+	// in user perceived characters.
+	MinLength int `json:"min_length"`
+	MaxLength int `json:"max_length"`
+	// MaxNewlines is the number of consecutive line breaks kept in the
+	// content. Additional ones are removed.
+	MaxNewlines int `json:"max_newlines"`
+}
+
+func (s *Settings) contentLimits() contentLimits {
+	return contentLimits{
+		MinLength:   s.MinContentLength,
+		MaxLength:   s.MaxContentLength,
+		MaxNewlines: s.MaxNewlines,
+	}
+}
+
+// normalizeContent returns plop content in the form it is stored in. Line
+// breaks are unified and limited to maxNewlines in a row, zero width
+// characters that do not join two visible characters are removed, surrounding
+// white space is trimmed and the result is NFC normalized. Normalization is
+// the last step, because removing a character can place a combining mark next
+// to its base character.
+func normalizeContent(content string, maxNewlines int) string {
+	content = lineBreaks.Replace(content)
+	content = stripZeroWidth(content)
+
+	var (
+		b strings.Builder
+		// blank is the number of skipped lines containing white space
+		// only, since the last line with any content.
+		blank int
+	)
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimFunc(line, isSpace) == "" {
+			blank++
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(strings.Repeat("\n", minInt(blank+1, maxNewlines)))
+		}
+		blank = 0
+		b.WriteString(line)
+	}
+	return norm.NFC.String(strings.TrimFunc(b.String(), isSpace))
+}
+
+// lineBreaks replaces all line break sequences with a new line character.
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\u2028", "\n", "\u2029", "\n")
+
+// Character classes used to normalize and validate content. The browser gets
+// them from contentCharClasses, so that it uses the same definitions.
+var (
+	// spaceChars is the same set unicode.IsSpace reports.
+	spaceChars     = unicode.White_Space
+	zeroWidthChars = &unicode.RangeTable{
+		R16: []unicode.Range16{
+			{Lo: 0x180e, Hi: 0x180e, Stride: 1},
+			{Lo: 0x200b, Hi: 0x200d, Stride: 1},
+			{Lo: 0x2060, Hi: 0x2060, Stride: 1},
+			{Lo: 0xfeff, Hi: 0xfeff, Stride: 1},
+		},
+	}
+	// joinerChars are the zero width characters kept between two visible
+	// characters.
+	joinerChars = &unicode.RangeTable{
+		R16: []unicode.Range16{
+			{Lo: 0x200c, Hi: 0x200d, Stride: 1},
+		},
+	}
+	// controlChars are control characters, except for a new line and a
+	// tab.
+	controlChars = &unicode.RangeTable{
+		R16: []unicode.Range16{
+			{Lo: 0x0000, Hi: 0x0008, Stride: 1},
+			{Lo: 0x000b, Hi: 0x001f, Stride: 1},
+			{Lo: 0x007f, Hi: 0x009f, Stride: 1},
+		},
+		LatinOffset: 3,
+	}
+	// bidiControlChars embed, override or isolate the direction of the
+	// text that follows. They can make the displayed text differ from its
+	// content. Directional marks are not included.
+	bidiControlChars = &unicode.RangeTable{
+		R16: []unicode.Range16{
+			{Lo: 0x202a, Hi: 0x202e, Stride: 1},
+			{Lo: 0x2066, Hi: 0x2069, Stride: 1},
+		},
+	}
+	// extendChars continue the preceding character, in addition to all
+	// marks (unicode.M). Those are the zero width joiner, conjoining jamo
+	// vowels and trailing consonants, emoji modifiers and tags.
+	extendChars = &unicode.RangeTable{
+		R16: []unicode.Range16{
+			{Lo: 0x1160, Hi: 0x11ff, Stride: 1},
+			{Lo: 0x200d, Hi: 0x200d, Stride: 1},
+		},
+		R32: []unicode.Range32{
+			{Lo: 0x1f3fb, Hi: 0x1f3ff, Stride: 1},
+			{Lo: 0xe0020, Hi: 0xe007f, Stride: 1},
+		},
+	}
+	regionalIndicatorChars = &unicode.RangeTable{
+		R32: []unicode.Range32{
+			{Lo: 0x1f1e6, Hi: 0x1f1ff, Stride: 1},
+		},
+	}
+)
+
+// contentCharClasses holds the character classes as space separated
+// hexadecimal code points and ranges of them, for example "9-d 20 85". They
+// are provided to the create plop form.
+type contentCharClasses struct {
+	Space             string
+	ZeroWidth         string
+	Joiner            string
+	Control           string
+	BidiControl       string
+	Extend            string
+	RegionalIndicator string
+}
+
+var charClasses = contentCharClasses{
+	Space:             formatCharClass(spaceChars),
+	ZeroWidth:         formatCharClass(zeroWidthChars),
+	Joiner:            formatCharClass(joinerChars),
+	Control:           formatCharClass(controlChars),
+	BidiControl:       formatCharClass(bidiControlChars),
+	Extend:            formatCharClass(extendChars),
+	RegionalIndicator: formatCharClass(regionalIndicatorChars),
+}
+
+func formatCharClass(t *unicode.RangeTable) string {
+	var ranges []string
+	add := func(lo, hi, stride uint32) {
+		if stride != 1 {
+			for c := lo; c <= hi; c += stride {
+				ranges = append(ranges, strconv.FormatUint(uint64(c), 16))
+			}
+			return
+		}
+		if lo == hi {
+			ranges = append(ranges, strconv.FormatUint(uint64(lo), 16))
+		} else {
+			ranges = append(ranges, strconv.FormatUint(uint64(lo), 16)+"-"+strconv.FormatUint(uint64(hi), 16))
+		}
+	}
+	for _, r := range t.R16 {
+		add(uint32(r.Lo), uint32(r.Hi), uint32(r.Stride))
+	}
+	for _, r := range t.R32 {
+		add(r.Lo, r.Hi, r.Stride)
+	}
+	return strings.Join(ranges, " ")
+}
+
+// stripZeroWidth removes zero width characters, except for a single joiner
+// placed between two visible characters, as used by emoji sequences and some
+// scripts.
+func stripZeroWidth(s string) string {
+	if strings.IndexFunc(s, isZeroWidth) == -1 {
+		return s
+	}
+	runes := []rune(s)
+	out := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		if !isZeroWidth(runes[i]) {
+			out = append(out, runes[i])
+			continue
+		}
+		// Consume the whole sequence of zero width characters at once.
+		end := i
+		for end < len(runes) && isZeroWidth(runes[end]) {
+			end++
+		}
+		joins := len(out) > 0 && isVisible(out[len(out)-1]) && end < len(runes) && isVisible(runes[end])
+		if joins {
+			for _, r := range runes[i:end] {
+				if unicode.Is(joinerChars, r) {
+					out = append(out, r)
+					break
+				}
+			}
+		}
+		i = end - 1
+	}
+	return string(out)
+}
+
+const zeroWidthJoiner = '\u200d'
+
+func isSpace(r rune) bool {
+	return unicode.Is(spaceChars, r)
+}
+
+func isZeroWidth(r rune) bool {
+	return unicode.Is(zeroWidthChars, r)
+}
+
+func isVisible(r rune) bool {
+	return !isSpace(r) && !isZeroWidth(r)
+}
+
+// validatePlopContent returns the translated description of why given
+// normalized plop content cannot be published, or an empty string if it is
+// valid.
+func validatePlopContent(l *i18n.Locale, limits contentLimits, content string) string {
+	if !utf8.ValidString(content) {
+		return l.T("Content is not valid text.")
+	}
+	for _, r := range content {
+		switch {
+		case unicode.Is(bidiControlChars, r):
+			return l.T("Content must not contain characters that change the text direction.")
+		case unicode.Is(controlChars, r):
+			return l.T("Content must not contain control characters.")
+		}
+	}
+	switch n := graphemeCount(content); {
+	case n < limits.MinLength:
+		return l.T("Content must be at least %d characters.", limits.MinLength)
+	case n > limits.MaxLength:
+		return l.T("Content must not be longer than %d characters.", limits.MaxLength)
+	}
+	return ""
+}
+
+// graphemeCount returns the number of user perceived characters in given
+// text. It approximates extended grapheme clusters as defined by UAX #29:
+// combining marks, variation selectors, emoji modifiers and tags extend the
+// preceding character, a zero width joiner joins the characters around it and
+// regional indicators form pairs.
+func graphemeCount(s string) int {
+	var (
+		n    int
+		prev rune
+		// flag is true if the current character is a regional indicator
+		// waiting for its pair.
+		flag bool
+	)
+	for _, r := range s {
+		switch {
+		case n > 0 && (prev == zeroWidthJoiner || isGraphemeExtend(r)):
+			// Continues the current character.
+		case n > 0 && flag && unicode.Is(regionalIndicatorChars, r):
+			flag = false
+		default:
+			n++
+			flag = unicode.Is(regionalIndicatorChars, r)
+		}
+		prev = r
+	}
+	return n
+}
+
+func isGraphemeExtend(r rune) bool {
+	return unicode.In(r, unicode.M, extendChars)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,242 @@
+package plopper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/husio/plopper/i18n"
+)
+
+func TestNormalizeContent(t *testing.T) {
+	cases := map[string]struct {
+		content string
+		want    string
+	}{
+		"plain":                        {content: "hello", want: "hello"},
+		"surrounding white space":      {content: "  \n hello \t\n", want: "hello"},
+		"decomposed":                   {content: "zaz\u0307o\u0301\u0142c\u0301", want: "zażółć"},
+		"windows line breaks":          {content: "a\r\nb\rc\u2028d", want: "a\nb\nc\nd"},
+		"single blank line":            {content: "a\n\nb", want: "a\n\nb"},
+		"too many line breaks":         {content: "a\n\n\n\n\nb", want: "a\n\nb"},
+		"white space only lines":       {content: "a\n \n\t\n  \nb", want: "a\n\nb"},
+		"zero width space":             {content: "a\u200b\u200bb", want: "ab"},
+		"leading zero width":           {content: "\u200b\u200dhello\ufeff", want: "hello"},
+		"emoji sequence":               {content: "\U0001F469\u200d\U0001F4BB", want: "\U0001F469\u200d\U0001F4BB"},
+		"repeated joiner":              {content: "\U0001F469\u200d\u200d\u200b\U0001F4BB", want: "\U0001F469\u200d\U0001F4BB"},
+		"joiner next to space":         {content: "a \u200d b", want: "a  b"},
+		"mark separated by zero width": {content: "e\u200b\u0301", want: "\u00e9"},
+		"non joiner between words":     {content: "\u0645\u06cc\u200c\u062e\u0648\u0627\u0647\u0645", want: "\u0645\u06cc\u200c\u062e\u0648\u0627\u0647\u0645"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := normalizeContent(tc.content, 2); got != tc.want {
+				t.Fatalf("want %+q, got %+q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGraphemeCount(t *testing.T) {
+	cases := map[string]int{
+		"":                               0,
+		"abc":                            3,
+		"zażółć":                         6,
+		"e\u0301":                        1,
+		"\U0001F44D\U0001F3FD":           1,
+		"\U0001F469\u200d\U0001F4BB!":    2,
+		"\U0001F1F5\U0001F1F1":           1,
+		"\U0001F1F5\U0001F1F1\U0001F1F5": 2,
+		"\u2764\ufe0f":                   1,
+		"\U0001F3F4\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F": 1,
+		"\u1100\u1161\u11a8": 1,
+		"a\nb":               3,
+	}
+	for s, want := range cases {
+		if got := graphemeCount(s); got != want {
+			t.Errorf("%+q: want %d, got %d", s, want, got)
+		}
+	}
+}
+
+func TestValidatePlopContent(t *testing.T) {
+	limits := contentLimits{MinLength: 3, MaxLength: 5, MaxNewlines: 2}
+	cases := map[string]struct {
+		content string
+		want    string
+	}{
+		"valid":                  {content: "hello"},
+		"tab and new line":       {content: "a\tb\nc"},
+		"emoji within limit":     {content: "\U0001F469\u200d\U0001F4BB\U0001F44D\U0001F3FD\U0001F1F5\U0001F1F1"},
+		"combining within limit": {content: "e\u0301e\u0301e\u0301"},
+		"too short":              {content: "ab", want: "Content must be at least 3 characters."},
+		"too long":               {content: "abcdef", want: "Content must not be longer than 5 characters."},
+		"control character":      {content: "ab\x07c", want: "Content must not contain control characters."},
+		"right to left override": {content: "ab\u202ec", want: "Content must not contain characters that change the text direction."},
+		"isolate":                {content: "ab\u2067c", want: "Content must not contain characters that change the text direction."},
+		"directional mark":       {content: "ab\u200fc"},
+		"invalid encoding":       {content: "ab\xffc", want: "Content is not valid text."},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := validatePlopContent(i18n.English, limits, tc.content); got != tc.want {
+				t.Fatalf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCreatePlopNormalizesContent(t *testing.T) {
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+	handler := newTestApplication(t, store, DefaultSettings())
+
+	content := "\u200bzaz\u0307o\u0301\u0142c\u0301\r\n\r\n\r\n\r\nge\u0328s\u0301la\u0300 ja\u0301z\u0301n\u0301 "
+	r := httptest.NewRequest("POST", "/create", strings.NewReader(url.Values{"content": {content}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Authorization", "Bearer alice")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("want 303, got %d:\n%s", w.Code, w.Body)
+	}
+
+	plops, err := store.ListPlops(context.Background(), PlopQuery{Statuses: []PlopStatus{PlopPublished}, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "zażółć\n\ngęślà jáźń"; len(plops) != 1 || plops[0].Content != want {
+		t.Fatalf("want %q stored, got %+v", want, plops)
+	}
+}
+
+// TestBrowserContentRules runs the content rules of main.js with the data
+// attributes of the rendered create form, and checks that the browser and the
+// server agree about the normalized content, its length and whether it
+// contains forbidden characters.
+func TestBrowserContentRules(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+	handler := newTestApplication(t, store, DefaultSettings())
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer alice")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	textarea := regexp.MustCompile(`<textarea[^>]* id="content"[^>]*>`).FindString(w.Body.String())
+	if textarea == "" {
+		t.Fatalf("no content field:\n%s", w.Body)
+	}
+	data := make(map[string]string)
+	for _, m := range regexp.MustCompile(`data-([a-z-]+)="([^"]*)"`).FindAllStringSubmatch(textarea, -1) {
+		// Convert the attribute name as element.dataset does.
+		key := regexp.MustCompile(`-[a-z]`).ReplaceAllStringFunc(m[1], func(s string) string {
+			return strings.ToUpper(s[1:])
+		})
+		data[key] = html.UnescapeString(m[2])
+	}
+
+	fixtures := []string{
+		"hello",
+		"  \n hello \t\n",
+		"zaz\u0307o\u0301\u0142c\u0301",
+		"a\r\nb\rc\u2028d\u2029e",
+		"a\n\n\n\n\nb",
+		"a\n \n\t\n  \nb",
+		"a\u200b\u200bb",
+		"\u200b\u200dhello\ufeff",
+		"\U0001F469\u200d\u200d\u200b\U0001F4BB",
+		"a \u200d b",
+		"\u0645\u06cc\u200c\u062e\u0648\u0627\u0647\u0645",
+		"\u0085hello\u0085",
+		"\u00a0\u1680\u2000\u200a\u202f\u205f\u3000hello\u3000",
+		"\u180ehello\u180e",
+		"a\u180e\u200cb",
+		"a\n\u0085\nb",
+		"e\u0301",
+		"\U0001F44D\U0001F3FD",
+		"\U0001F1F5\U0001F1F1\U0001F1F5",
+		"\u2764\ufe0f",
+		"\U0001F3F4\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F",
+		"\u1100\u1161\u11a8",
+		"\uac00\u11a8",
+		"ab\x07c",
+		"ab\u0085c",
+		"ab\u202ec",
+		"ab\u2067c",
+		"ab\u200fc",
+		"a\vb\fc",
+		"e\u200b\u0301",
+	}
+	type result struct {
+		Content   string `json:"content"`
+		Length    int    `json:"length"`
+		Forbidden bool   `json:"forbidden"`
+	}
+
+	mainJS, err := os.ReadFile("static/main.js")
+	if err != nil {
+		t.Fatalf("cannot read main.js: %s", err)
+	}
+	dataJSON, _ := json.Marshal(data)
+	var script bytes.Buffer
+	script.WriteString("var document = {cookie: \"\", addEventListener: function() {}};\n")
+	script.Write(mainJS)
+	script.WriteString(`
+var rules = contentRules(` + string(dataJSON) + `)
+var fixtures = JSON.parse(require("fs").readFileSync(0, "utf8"))
+process.stdout.write(JSON.stringify(fixtures.map(function(s) {
+	var content = rules.normalize(s)
+	return {content: content, length: rules.length(content), forbidden: rules.forbidden(content)}
+})))
+`)
+	stdin, _ := json.Marshal(fixtures)
+	cmd := exec.Command(node, "-e", script.String())
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("cannot run main.js: %s", err)
+	}
+	var got []result
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("cannot decode main.js output: %s\n%s", err, out)
+	}
+	if len(got) != len(fixtures) {
+		t.Fatalf("want %d results, got %d", len(fixtures), len(got))
+	}
+
+	for i, s := range fixtures {
+		content := normalizeContent(s, DefaultSettings().MaxNewlines)
+		want := result{
+			Content: content,
+			Length:  graphemeCount(content),
+			Forbidden: strings.IndexFunc(content, func(r rune) bool {
+				return unicode.In(r, controlChars, bidiControlChars)
+			}) != -1,
+		}
+		if got[i] != want {
+			t.Errorf("%+q: want %+v, got %+v", s, want, got[i])
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package plopper
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// CSRFMiddleware returns an http.Handler middleware that protects all unsafe
+// requests authenticated with a cookie against cross site request forgery.
+//
+// Signed double-submit cookie pattern is used. Each client receives a random
+// seed stored in a cookie. The token that must be submitted together with the
+// form (or via the X-CSRF-Token header) is the HMAC of that seed and of the
+// lith session cookie. Binding the token to the session means that a seed and
+// token pair obtained by an attacker on their own visit is not valid for the
+// session of anyone else, even if the attacker can set the seed cookie in the
+// victim's browser.
+//
+// Requests authenticated with a Bearer token are not protected, because a
+// browser never attaches such header on its own.
+//
+// Within decorated http.Handler, CSRFToken function can be called to retrieve
+// the token that must be submitted.
+func CSRFMiddleware(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &csrfMiddleware{
+			secret: secret,
+			next:   next,
+		}
+	}
+}
+
+type csrfMiddleware struct {
+	secret []byte
+	next   http.Handler
+}
+
+const (
+	csrfCookieName = "csrf"
+	csrfFormField  = "csrf_token"
+	csrfHeader     = "X-CSRF-Token"
+
+	// sessionCookieName is the cookie lith authenticates the browser with.
+	sessionCookieName = "s"
+)
+
+func (m *csrfMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var seed string
+	if c, err := r.Cookie(csrfCookieName); err == nil && c.Value != "" {
+		seed = c.Value
+	} else {
+		seed = newCSRFSeed()
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookieName,
+			Value:    seed,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	var session string
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		session = c.Value
+	}
+	token := m.sign(seed, session)
+
+	if !isSafeMethod(r.Method) && !isBearerRequest(r) {
+		submitted := r.Header.Get(csrfHeader)
+		if submitted == "" {
+			submitted = r.PostFormValue(csrfFormField)
+		}
+		if !hmac.Equal([]byte(submitted), []byte(token)) {
+			renderFail(w, r, http.StatusForbidden, "Invalid or missing CSRF token. Reload the page and try again.")
+			return
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), csrfTokenContextKey, token)
+	m.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (m *csrfMiddleware) sign(seed, session string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	_, _ = mac.Write([]byte(seed + "|" + session))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newCSRFSeed() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// isBearerRequest returns true if the request is authenticated only with the
+// Authorization header. If a session cookie is present, lith authentication
+// prefers it, so such request must be protected.
+func isBearerRequest(r *http.Request) bool {
+	if _, err := r.Cookie(sessionCookieName); err == nil {
+		return false
+	}
+	return strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// CSRFToken returns the token that must be submitted with any unsafe request.
+// An empty string is returned if the context was not created by
+// CSRFMiddleware.
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfTokenContextKey).(string)
+	return token
+}
@@ -0,0 +1,133 @@
+package plopper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	var token string
+	handler := CSRFMiddleware([]byte("secret"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want GET to pass, got %d", w.Code)
+	}
+	if token == "" {
+		t.Fatal("token not provided in context")
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName {
+		t.Fatalf("want CSRF cookie set, got %v", cookies)
+	}
+	seed := cookies[0]
+
+	cases := map[string]struct {
+		form     url.Values
+		header   http.Header
+		cookies  []*http.Cookie
+		wantCode int
+	}{
+		"valid form token": {
+			form:     url.Values{csrfFormField: {token}},
+			cookies:  []*http.Cookie{seed},
+			wantCode: http.StatusOK,
+		},
+		"valid header token": {
+			header:   http.Header{csrfHeader: {token}},
+			cookies:  []*http.Cookie{seed},
+			wantCode: http.StatusOK,
+		},
+		"missing token": {
+			cookies:  []*http.Cookie{seed},
+			wantCode: http.StatusForbidden,
+		},
+		"missing cookie": {
+			form:     url.Values{csrfFormField: {token}},
+			wantCode: http.StatusForbidden,
+		},
+		"token of a different seed": {
+			form:     url.Values{csrfFormField: {token}},
+			cookies:  []*http.Cookie{{Name: csrfCookieName, Value: "attacker-seed"}},
+			wantCode: http.StatusForbidden,
+		},
+		"unsigned seed used as token": {
+			form:     url.Values{csrfFormField: {seed.Value}},
+			cookies:  []*http.Cookie{seed},
+			wantCode: http.StatusForbidden,
+		},
+		"bearer token request": {
+			header:   http.Header{"Authorization": {"Bearer xyz"}},
+			wantCode: http.StatusOK,
+		},
+		"bearer token request with session cookie": {
+			header:   http.Header{"Authorization": {"Bearer xyz"}},
+			cookies:  []*http.Cookie{{Name: "s", Value: "xyz"}},
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for testName, tc := range cases {
+		t.Run(testName, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/create", strings.NewReader(tc.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for name, values := range tc.header {
+				for _, v := range values {
+					r.Header.Add(name, v)
+				}
+			}
+			for _, c := range tc.cookies {
+				r.AddCookie(c)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tc.wantCode {
+				t.Fatalf("want %d status, got %d", tc.wantCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestCSRFTokenBoundToSession(t *testing.T) {
+	var token string
+	handler := CSRFMiddleware([]byte("secret"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r.Context())
+	}))
+
+	// The attacker gets a valid seed and token pair for their own session.
+	seed := &http.Cookie{Name: csrfCookieName, Value: "seed"}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(seed)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "attacker"})
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	cases := map[string]struct {
+		session  string
+		wantCode int
+	}{
+		"same session":    {session: "attacker", wantCode: http.StatusOK},
+		"another session": {session: "victim", wantCode: http.StatusForbidden},
+		"no session":      {wantCode: http.StatusForbidden},
+	}
+	for testName, tc := range cases {
+		t.Run(testName, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/create", strings.NewReader(url.Values{csrfFormField: {token}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.AddCookie(seed)
+			if tc.session != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tc.session})
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tc.wantCode {
+				t.Fatalf("want %d status, got %d", tc.wantCode, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package plopper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Export is written in the JSON Lines format. Each line is a single record,
+// with the type field describing the kind of the record. Only plops are
+// exported for now, but replies and reactions are expected to be added as
+// new record types.
+const recordPlop = "plop"
+
+// plopRecord is the serialized representation of a plop used by export and
+// import.
+type plopRecord struct {
+	Type      string     `json:"type"`
+	ID        string     `json:"id"`
+	AuthorID  string     `json:"author_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	Content   string     `json:"content"`
+	Status    PlopStatus `json:"status"`
+}
+
+const (
+	exportPageSize  = 500
+	importBatchSize = 500
+)
+
+// ExportPlops writes all plops, newest first, to given writer. Plops are
+// fetched in pages, so the export does not hold the whole store in memory.
+// It returns the number of written plops.
+func ExportPlops(ctx context.Context, plops PlopStore, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	var (
+		total int
+		q     = PlopQuery{Limit: exportPageSize}
+	)
+	for {
+		page, err := plops.ListPlops(ctx, q)
+		if err != nil {
+			return total, fmt.Errorf("cannot list plops: %w", err)
+		}
+		for _, p := range page {
+			rec := plopRecord{
+				Type:      recordPlop,
+				ID:        p.ID.String(),
+				AuthorID:  p.AuthorID,
+				CreatedAt: p.CreatedAt,
+				Content:   p.Content,
+				Status:    p.Status,
+			}
+			if !p.EditedAt.IsZero() {
+				editedAt := p.EditedAt
+				rec.EditedAt = &editedAt
+			}
+			if err := enc.Encode(rec); err != nil {
+				return total, fmt.Errorf("cannot write plop: %w", err)
+			}
+			total++
+		}
+		if len(page) < exportPageSize {
+			return total, nil
+		}
+		last := page[len(page)-1]
+		q.OlderThan, q.OlderThanID = last.CreatedAt, last.ID
+	}
+}
+
+// ImportResult describes the outcome of an import.
+type ImportResult struct {
+	// Imported is the number of plops added to the store.
+	Imported int
+	// Skipped is the number of plops that were already present in the
+	// store.
+	Skipped int
+}
+
+// ImportPlops reads records written by ExportPlops and inserts them into the
+// store, preserving IDs and creation times. Plops that already exist are
+// skipped, so the import of the same data can be safely repeated.
+//
+// Plops are inserted in batches, each in a separate transaction. If the
+// import fails, all batches preceding the failure are kept.
+func ImportPlops(ctx context.Context, plops PlopStore, r io.Reader) (ImportResult, error) {
+	var (
+		result ImportResult
+		batch  = make([]*Plop, 0, importBatchSize)
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := plops.InsertPlops(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("cannot insert plops: %w", err)
+		}
+		result.Imported += n
+		result.Skipped += len(batch) - n
+		batch = batch[:0]
+		return nil
+	}
+
+	for line, input := 1, bufio.NewReader(r); ; line++ {
+		raw, err := input.ReadBytes('\n')
+		if len(raw) != 0 {
+			p, perr := decodePlopRecord(raw)
+			if perr != nil {
+				return result, fmt.Errorf("line %d: %w", line, perr)
+			}
+			if p != nil {
+				batch = append(batch, p)
+			}
+			if len(batch) == importBatchSize {
+				if err := flush(); err != nil {
+					return result, err
+				}
+			}
+		}
+		if err == io.EOF {
+			return result, flush()
+		}
+		if err != nil {
+			return result, fmt.Errorf("cannot read input: %w", err)
+		}
+	}
+}
+
+// decodePlopRecord returns the plop serialized in given line, or nil if the
+// line is empty.
+func decodePlopRecord(raw []byte) (*Plop, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+	var rec plopRecord
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if rec.Type != recordPlop {
+		return nil, fmt.Errorf("unsupported record type %q", rec.Type)
+	}
+	id, err := hex.DecodeString(rec.ID)
+	if err != nil || len(id) != 16 {
+		return nil, fmt.Errorf("invalid plop ID %q", rec.ID)
+	}
+	if rec.AuthorID == "" || rec.CreatedAt.IsZero() {
+		return nil, fmt.Errorf("plop %s: author and creation time are required", rec.ID)
+	}
+	switch rec.Status {
+	case PlopPublished, PlopHeld, PlopRejected, PlopHidden:
+	default:
+		return nil, fmt.Errorf("plop %s: invalid status %q", rec.ID, rec.Status)
+	}
+	p := &Plop{
+		ID:        id,
+		AuthorID:  rec.AuthorID,
+		CreatedAt: rec.CreatedAt.UTC(),
+		Content:   rec.Content,
+		Status:    rec.Status,
+	}
+	if rec.EditedAt != nil {
+		p.EditedAt = rec.EditedAt.UTC()
+	}
+	return p, nil
+}
@@ -0,0 +1,92 @@
+package plopper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	src, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open source store: %s", err)
+	}
+	defer src.Close()
+
+	// More plops than fit into a single export page and import batch.
+	const total = exportPageSize + importBatchSize/2 + 7
+	statuses := []PlopStatus{PlopPublished, PlopHeld, PlopRejected, PlopHidden}
+	for i := 0; i < total; i++ {
+		if _, err := src.Create(ctx, fmt.Sprintf("author-%d", i%7), fmt.Sprintf("plop %d", i), statuses[i%len(statuses)]); err != nil {
+			t.Fatalf("cannot create plop: %s", err)
+		}
+	}
+
+	var export bytes.Buffer
+	if n, err := ExportPlops(ctx, src, &export); err != nil || n != total {
+		t.Fatalf("want %d plops exported, got %d, %v", total, n, err)
+	}
+
+	dst, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open destination store: %s", err)
+	}
+	defer dst.Close()
+
+	res, err := ImportPlops(ctx, dst, bytes.NewReader(export.Bytes()))
+	if err != nil || res.Imported != total || res.Skipped != 0 {
+		t.Fatalf("unexpected first import result: %+v, %v", res, err)
+	}
+	// Import is idempotent.
+	res, err = ImportPlops(ctx, dst, bytes.NewReader(export.Bytes()))
+	if err != nil || res.Imported != 0 || res.Skipped != total {
+		t.Fatalf("unexpected second import result: %+v, %v", res, err)
+	}
+
+	want, err := src.ListPlops(ctx, PlopQuery{Limit: 2 * total})
+	if err != nil {
+		t.Fatalf("cannot list source plops: %s", err)
+	}
+	got, err := dst.ListPlops(ctx, PlopQuery{Limit: 2 * total})
+	if err != nil {
+		t.Fatalf("cannot list imported plops: %s", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d plops, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(want[i], got[i]) {
+			t.Fatalf("plop %d differs:\nwant %+v\n got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestImportRejectsInvalidRecords(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open store: %s", err)
+	}
+	defer store.Close()
+
+	created := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC).Format(time.RFC3339Nano)
+	valid := `{"type":"plop","id":"00000000000000000000000000000001","author_id":"a","created_at":"` + created + `","content":"x","status":"published"}`
+	cases := map[string]string{
+		"unknown type":   `{"type":"reaction"}`,
+		"invalid id":     strings.Replace(valid, "00000000000000000000000000000001", "xyz", 1),
+		"invalid status": strings.Replace(valid, "published", "gone", 1),
+		"not json":       `plop`,
+	}
+	for name, record := range cases {
+		input := valid + "\n\n" + record + "\n"
+		if _, err := ImportPlops(ctx, store, strings.NewReader(input)); err == nil || !strings.Contains(err.Error(), "line 3") {
+			t.Errorf("%s: want line 3 error, got %v", name, err)
+		}
+	}
+}
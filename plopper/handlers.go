@@ -2,46 +2,105 @@ package plopper
 
 import (
 	"bytes"
-	_ "embed"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/husio/plopper/i18n"
 	"github.com/husio/plopper/lith"
+	"github.com/husio/plopper/logging"
 )
 
-func NewHTTPApplication(plops PlopStore, auth *lith.Client, authUI string) http.Handler {
-	withAuth := lith.AuthMiddleware(auth)
+func NewHTTPApplication(plops PlopStore, auth *lith.Client, moderator Moderator, conf Settings) http.Handler {
+	settings := &conf
+	templates := embeddedTemplates
+	if conf.TemplateDir != "" {
+		templates = newReloadingTemplates(os.DirFS(conf.TemplateDir))
+	}
+	profiles := newProfileResolver(auth, plops)
+	authenticate := lith.AuthMiddleware(auth)
+	withAuth := func(next http.Handler) http.Handler {
+		return authenticate(&recordAccountMiddleware{
+			next: &viewerProfileMiddleware{plops: plops, next: next},
+		})
+	}
 
+	listPlops := &listPlopsHandler{plops: plops, profiles: profiles, conf: settings}
 	mux := http.NewServeMux()
-	mux.Handle("/", withAuth(&listPlopsHandler{plops: plops}))
+	mux.Handle("/", instrument("list-plops", withAuth(listPlops)))
 
-	http.Handle("/accounts/", http.StripPrefix("/accounts/", revproxy(authUI)))
+	http.Handle("/accounts/", http.StripPrefix("/accounts/", revproxy(conf.AuthUI)))
 	// Static files require "/pub/" statics.
-	http.Handle("/pub/", http.StripPrefix("/pub/", revproxy(authUI)))
-
-	mux.Handle("/create", withAuth(&requireLoginMiddleware{
+	http.Handle("/pub/", http.StripPrefix("/pub/", revproxy(conf.AuthUI)))
+
+	mux.Handle("/create", instrument("create-plop", withAuth(&requireLoginMiddleware{
+		loginURL:   "/accounts/login/",
+		permission: conf.CreatePermission,
+		next: &createPlopHandler{
+			plops:     plops,
+			moderator: moderator,
+			list:      listPlops,
+			conf:      settings,
+			limiter:   newRateLimiter(conf.CreateRateLimit, conf.CreateRateWindow),
+		},
+	})))
+	mux.Handle("/plop/", instrument("show-plop", http.StripPrefix("/plop/", withAuth(&showPlopHandler{plops: plops, profiles: profiles, conf: settings}))))
+	mux.Handle("/report/", instrument("report-plop", withAuth(&requireLoginMiddleware{
+		loginURL: "/accounts/login/",
+		next:     http.StripPrefix("/report/", &reportPlopHandler{plops: plops, profiles: profiles, conf: settings}),
+	})))
+	mux.Handle("/author/", instrument("author", withAuth(http.StripPrefix("/author/", &authorHandler{plops: plops, profiles: profiles, conf: settings}))))
+	mux.Handle("/follow", instrument("follow", withAuth(&requireLoginMiddleware{
 		loginURL: "/accounts/login/",
-		next:     &createPlopHandler{plops: plops},
-	}))
-	mux.Handle("/plop/", http.StripPrefix("/plop/", &showPlopHandler{plops: plops}))
-	return mux
+		next:     &followHandler{plops: plops},
+	})))
+	mux.Handle("/relations/", instrument("relations", withAuth(&requireLoginMiddleware{
+		loginURL: "/accounts/login/",
+		next:     &relationsHandler{plops: plops},
+	})))
+	mux.Handle("/settings", instrument("settings", withAuth(&requireLoginMiddleware{
+		loginURL: "/accounts/login/",
+		next:     &settingsHandler{plops: plops, profiles: profiles},
+	})))
+	mux.Handle("/language", instrument("language", &languageHandler{}))
+	mux.Handle("/api/relations", instrument("api-relations", withAuth(&relationsAPIHandler{plops: plops})))
+	mux.Handle("/moderation/", instrument("moderation", withAuth(&requireLoginMiddleware{
+		loginURL:   "/accounts/login/",
+		permission: conf.ModeratePermission,
+		next:       http.StripPrefix("/moderation", newModerationHandler(plops, profiles, settings)),
+	})))
+	mux.Handle("/static/", instrument("static", http.StripPrefix("/static/", statics)))
+
+	mux.Handle("/healthz", &healthzHandler{})
+	mux.Handle("/readyz", &readyzHandler{checks: map[string]func(context.Context) error{
+		"store": plops.Ping,
+		"lith":  auth.Ping,
+	}})
+
+	withCSRF := CSRFMiddleware(conf.CSRFSecret)
+	withLanguage := LanguageMiddleware()
+	withSecurityHeaders := SecurityHeadersMiddleware()
+	withTemplates := templatesMiddleware(templates)
+	return withTemplates(withSecurityHeaders(withLanguage(withCSRF(mux))))
 }
 
-const (
-	plopsPerPage          = 50
-	plopPaginationDateFmt = "2006-01-02_15-04-05"
-)
+const plopPaginationDateFmt = "2006-01-02_15-04-05"
 
 type requireLoginMiddleware struct {
 	next     http.Handler
 	loginURL string
+	// permission if not empty, is required to be granted to the current
+	// account.
+	permission string
 }
 
 func (m requireLoginMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -52,8 +111,8 @@ func (m requireLoginMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if !contains(account.Permissions, "plop:create") {
-		renderFail(w, http.StatusForbidden, `"plop:create" permission is required.`)
+	if m.permission != "" && !contains(account.Permissions, m.permission) {
+		renderFail(w, r, http.StatusForbidden, "%q permission is required.", m.permission)
 		return
 	}
 
@@ -70,145 +129,468 @@ func contains(collection []string, element string) bool {
 }
 
 type showPlopHandler struct {
-	plops PlopStore
+	plops    PlopStore
+	profiles *profileResolver
+	conf     *Settings
 }
 
 func (h *showPlopHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "HEAD") {
+		return
+	}
 	id, err := hex.DecodeString(r.URL.Path)
 	if err != nil {
-		renderStd(w, http.StatusNotFound)
+		renderStd(w, r, http.StatusNotFound)
 		return
 	}
 
 	switch plop, err := h.plops.Plop(r.Context(), id); {
 	case err == nil:
-		render(w, "show-plop", plop)
+		if plop.Status != PlopPublished && !h.conf.isModerator(r.Context()) {
+			renderStd(w, r, http.StatusNotFound)
+			return
+		}
+		r = h.profiles.withProfiles(r, []string{plop.AuthorID})
+		render(w, r, http.StatusOK, "show-plop", plop)
 	case errors.Is(err, ErrNotFound):
-		renderStd(w, http.StatusNotFound)
+		renderStd(w, r, http.StatusNotFound)
 	default:
-		log.Printf("cannot get plop %q: %s", id, err)
-		renderStd(w, http.StatusInternalServerError)
+		logging.Errorf(r.Context(), "cannot get plop %q: %s", id, err)
+		renderStd(w, r, http.StatusInternalServerError)
 	}
 }
 
+type listPlopsView struct {
+	Plops []*Plop `json:"plops"`
+	// Account is the authenticated account. It is nil for anonymous
+	// clients. The session is never serialized.
+	Account *lith.AccountSession `json:"-"`
+	// IsModerator is true if the account can moderate plops.
+	IsModerator bool   `json:"-"`
+	Timeline    string `json:"timeline"`
+	IsNewest    bool   `json:"is_newest"`
+	// NextPage is the cursor of the next page of older plops. It is empty
+	// if there are no more plops.
+	NextPage string `json:"next_page,omitempty"`
+	// Limits describe what content the create plop form accepts.
+	Limits contentLimits `json:"content_limits"`
+	// Form is the state of the create plop form. It is only set if the
+	// submitted plop was not accepted.
+	Form createPlopForm `json:"-"`
+}
+
+// createPlopForm is the submitted create plop form, displayed again together
+// with the reason it was not accepted.
+type createPlopForm struct {
+	Content string
+	// Error is the translated description of why the plop was not
+	// accepted.
+	Error string
+}
+
 type listPlopsHandler struct {
-	plops PlopStore
+	plops    PlopStore
+	profiles *profileResolver
+	conf     *Settings
 }
 
 func (h *listPlopsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	olderThan := time.Now().UTC()
-	isNewest := true
-	query := r.URL.Query()
-	if raw := query.Get("olderThan"); raw != "" {
-		if t, err := time.Parse(plopPaginationDateFmt, raw); err == nil {
-			olderThan = t
-			isNewest = false
-		}
+	// All paths not handled by other handlers end up here.
+	if r.URL.Path != "/" {
+		renderStd(w, r, http.StatusNotFound)
+		return
+	}
+	if !allowMethods(w, r, "GET", "HEAD") {
+		return
 	}
+	h.renderList(w, r, http.StatusOK, createPlopForm{})
+}
 
-	plops, err := h.plops.ListPlops(r.Context(), olderThan, plopsPerPage)
+// renderList writes the list page with given create plop form state.
+func (h *listPlopsHandler) renderList(w http.ResponseWriter, r *http.Request, code int, form createPlopForm) {
+	olderThan, olderThanID, isNewest := paginationCursor(r)
+
+	account, _ := lith.CurrentAccount(r.Context())
+	timeline := selectTimeline(w, r, account)
+
+	q := PlopQuery{
+		OlderThan:   olderThan,
+		OlderThanID: olderThanID,
+		Statuses:    []PlopStatus{PlopPublished},
+		Limit:       h.conf.PlopsPerPage,
+	}
+	if h.conf.isModerator(r.Context()) {
+		q.Statuses = append(q.Statuses, PlopHidden)
+	}
+	if account != nil {
+		q.ViewerID = account.AccountID
+		if timeline == timelineFollowing {
+			q.FollowedBy = account.AccountID
+		}
+	}
+	plops, err := h.plops.ListPlops(r.Context(), q)
 	if err != nil {
-		log.Printf("cannot list plops: %s", err)
-		renderStd(w, http.StatusInternalServerError)
+		logging.Errorf(r.Context(), "cannot list plops: %s", err)
+		renderStd(w, r, http.StatusInternalServerError)
 		return
 	}
 
-	var nextPage string
-	if len(plops) == plopsPerPage {
-		nextPage = plops[plopsPerPage-1].CreatedAt.Format(plopPaginationDateFmt)
+	accounts := plopAuthors(plops)
+	if account != nil {
+		accounts = append(accounts, account.AccountID)
 	}
+	r = h.profiles.withProfiles(r, accounts)
+
+	render(w, r, code, "list-plops", listPlopsView{
+		Plops:       plops,
+		Account:     account,
+		IsModerator: h.conf.isModerator(r.Context()),
+		Timeline:    timeline,
+		IsNewest:    isNewest,
+		NextPage:    nextPageCursor(plops, h.conf.PlopsPerPage),
+		Limits:      h.conf.contentLimits(),
+		Form:        form,
+	})
+}
 
-	account, _ := lith.CurrentAccount(r.Context())
+const (
+	timelineGlobal    = "global"
+	timelineFollowing = "following"
+)
 
-	render(w, "list-plops", struct {
-		Plops    []*Plop
-		Account  *lith.AccountSession
-		IsNewest bool
-		NextPage string
-	}{
-		Plops:    plops,
-		Account:  account,
-		IsNewest: isNewest,
-		NextPage: nextPage,
-	})
+// selectTimeline returns the timeline that should be displayed to the client.
+// Explicitly selected timeline is remembered and becomes the default one,
+// unless the account has chosen a default timeline in the settings.
+func selectTimeline(w http.ResponseWriter, r *http.Request, account *lith.AccountSession) string {
+	if account == nil {
+		return timelineGlobal
+	}
+	switch t := r.URL.Query().Get("timeline"); t {
+	case timelineGlobal, timelineFollowing:
+		http.SetCookie(w, &http.Cookie{
+			Name:     "timeline",
+			Value:    t,
+			Path:     "/",
+			MaxAge:   365 * 24 * 3600,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return t
+	}
+	if t := viewerProfile(r.Context()).DefaultTimeline; t != "" {
+		return t
+	}
+	if c, err := r.Cookie("timeline"); err == nil && c.Value == timelineFollowing {
+		return timelineFollowing
+	}
+	return timelineGlobal
+}
+
+// paginationCursor returns the position of the last plop of the previous page.
+// If no cursor is provided, the newest plops should be returned.
+func paginationCursor(r *http.Request) (olderThan time.Time, olderThanID PlopID, isNewest bool) {
+	query := r.URL.Query()
+	if raw := query.Get("before"); raw != "" {
+		chunks := strings.SplitN(raw, "-", 2)
+		if len(chunks) == 2 {
+			nsec, errT := strconv.ParseInt(chunks[0], 10, 64)
+			id, errID := hex.DecodeString(chunks[1])
+			if errT == nil && errID == nil {
+				return time.Unix(0, nsec).UTC(), id, false
+			}
+		}
+	}
+	// Links using the old pagination format may still be around.
+	if raw := query.Get("olderThan"); raw != "" {
+		if t, err := time.Parse(plopPaginationDateFmt, raw); err == nil {
+			return t, nil, false
+		}
+	}
+	return time.Time{}, nil, true
+}
+
+// nextPageCursor returns the cursor of the page following given one, or an
+// empty string if given page is the last one.
+func nextPageCursor(plops []*Plop, perPage int) string {
+	if len(plops) < perPage {
+		return ""
+	}
+	last := plops[len(plops)-1]
+	return fmt.Sprintf("%d-%s", last.CreatedAt.UnixNano(), last.ID)
 }
 
 type createPlopHandler struct {
-	plops PlopStore
+	plops     PlopStore
+	moderator Moderator
+	// list renders the list page, which displays the create plop form
+	// together with validation errors.
+	list    *listPlopsHandler
+	conf    *Settings
+	limiter *rateLimiter
 }
 
 func (h createPlopHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	account, ok := lith.CurrentAccount(r.Context())
 	if !ok {
-		renderFail(w, http.StatusUnauthorized, "Not logged in.")
+		renderFail(w, r, http.StatusUnauthorized, "Not logged in.")
 		return
 	}
 
-	if r.Method != "POST" {
-		renderStd(w, http.StatusMethodNotAllowed)
+	if !allowMethods(w, r, "POST") {
 		return
 	}
 	if err := r.ParseForm(); err != nil {
-		renderFail(w, http.StatusBadRequest, fmt.Sprintf("cannot parse form: %s", err))
+		renderFail(w, r, http.StatusBadRequest, "Cannot parse form: %s", err)
 		return
 	}
 
-	content := r.Form.Get("content")
-	switch n := len(strings.TrimSpace(content)); {
-	case n < 3:
-		renderFail(w, http.StatusBadRequest, "Content must be more than 3 characters")
+	locale := i18n.FromContext(r.Context())
+	limits := h.conf.contentLimits()
+	// The submitted content is displayed again if rejected, so that the
+	// author can correct it.
+	submitted := r.Form.Get("content")
+	content := normalizeContent(submitted, limits.MaxNewlines)
+	if msg := validatePlopContent(locale, limits, content); msg != "" {
+		h.reject(w, r, http.StatusBadRequest, submitted, msg)
 		return
-	case n > 1024:
-		renderFail(w, http.StatusBadRequest, "Content must be more less than 1024 characters")
+	}
+
+	if !h.limiter.Allow(account.AccountID, time.Now()) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.conf.CreateRateWindow.Seconds())))
+		h.reject(w, r, http.StatusTooManyRequests, submitted, locale.T("You are creating plops too fast. Try again later."))
+		return
+	}
+
+	plop := &Plop{
+		AuthorID:  account.AccountID,
+		CreatedAt: time.Now().UTC(),
+		Content:   content,
+	}
+	verdict, err := h.moderator.Moderate(r.Context(), plop)
+	if err != nil {
+		logging.Errorf(r.Context(), "cannot moderate a plop: %s", err)
+		renderStd(w, r, http.StatusInternalServerError)
 		return
 	}
+	plop.Status = verdict.Status
 
-	if _, err := h.plops.Create(r.Context(), account.AccountID, content); err != nil {
-		log.Printf("cannot create a plop: %s", err)
-		renderStd(w, http.StatusInternalServerError)
+	plop.ID, err = h.plops.Create(r.Context(), plop.AuthorID, plop.Content, plop.Status)
+	if err != nil {
+		logging.Errorf(r.Context(), "cannot create a plop: %s", err)
+		renderStd(w, r, http.StatusInternalServerError)
 		return
 	}
+	if n, ok := h.moderator.(ModerationNotifier); ok {
+		n.PlopCreated(r.Context(), plop)
+	}
+	plopsCreated.Inc(string(plop.Status))
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	switch plop.Status {
+	case PlopPublished:
+		if wantsJSON(w, r) {
+			h.writeCreated(w, r, plop)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	case PlopHeld:
+		// A moderator decides whether the plop is published, so the
+		// filter reason is not shown to the author.
+		renderFail(w, r, http.StatusAccepted, "Your plop awaits moderation.")
+	default:
+		h.reject(w, r, http.StatusBadRequest, submitted, locale.T("Your plop was rejected. %s", verdict.Reason))
+	}
 }
 
-func render(w http.ResponseWriter, templateName string, context interface{}) {
-	var b bytes.Buffer
-
-	if err := tmpl.ExecuteTemplate(&b, templateName, context); err != nil {
-		const code = http.StatusInternalServerError
-		http.Error(w, http.StatusText(code), code)
-		log.Printf("cannot render %q template: %v", templateName, err)
+// reject writes the reason why the submitted plop was not accepted. Browsers
+// get the list page with the form filled with the submitted content, so that
+// it is not lost. Clients that prefer JSON get a problem document.
+func (h createPlopHandler) reject(w http.ResponseWriter, r *http.Request, code int, content, msg string) {
+	if wantsJSON(w, r) {
+		p := newProblem(r, code)
+		p.Detail = msg
+		writeJSONAs(w, r, code, "application/problem+json", p)
 		return
 	}
-	_, _ = b.WriteTo(w)
+	h.list.renderList(w, r, code, createPlopForm{Content: content, Error: msg})
 }
 
-func renderStd(w http.ResponseWriter, code int) {
-	render(w, "std", http.StatusText(code))
+// createdPlopView is the JSON representation of a published plop. It allows
+// scripts to insert the plop into the page without reloading it.
+type createdPlopView struct {
+	Plop *Plop `json:"plop"`
+	// HTML is the plop rendered the same way as on the list page.
+	HTML template.HTML `json:"html"`
 }
 
-func renderFail(w http.ResponseWriter, code int, description string) {
+func (h createPlopHandler) writeCreated(w http.ResponseWriter, r *http.Request, plop *Plop) {
+	r = h.list.profiles.withProfiles(r, []string{plop.AuthorID})
 	var b bytes.Buffer
+	if err := executePartial(&b, r, "render-plop", plop); err != nil {
+		logging.Errorf(r.Context(), "cannot render plop %s: %s", plop.ID, err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Location", "/plop/"+plop.ID.String())
+	writeJSON(w, r, http.StatusCreated, createdPlopView{Plop: plop, HTML: template.HTML(b.String())})
+}
 
-	context := struct {
-		Description string
-		Code        int
-	}{
-		Description: description,
-		Code:        code,
+// render writes the page rendered with given context, using given status
+// code. Clients that prefer JSON get the context serialized instead.
+func render(w http.ResponseWriter, r *http.Request, code int, page string, context interface{}) {
+	if wantsJSON(w, r) {
+		writeJSON(w, r, code, context)
+		return
 	}
-	if err := tmpl.ExecuteTemplate(&b, "fail", context); err != nil {
-		log.Printf("cannot render fail template: %v", err)
-		renderStd(w, http.StatusInternalServerError)
+	err := writePage(w, r, code, page, context)
+	if err == nil {
 		return
 	}
+	logging.Errorf(r.Context(), "cannot render %q page with %d status for %s %s: %v", page, code, r.Method, r.URL.Path, err)
+
+	// The error page might be broken as well, so it is rendered without
+	// falling back to render.
+	const failCode = http.StatusInternalServerError
+	view := newStatusView(r, failCode, "")
+	if requestTemplates(r.Context()).reload {
+		// Template errors are expected during development.
+		view.Description = err.Error()
+	}
+	if err := writePage(w, r, failCode, statusPage(failCode), view); err != nil {
+		logging.Errorf(r.Context(), "cannot render %q page: %v", statusPage(failCode), err)
+		http.Error(w, view.Title, failCode)
+	}
+}
+
+// writePage writes the page rendered with given context. Nothing is written
+// if the page cannot be rendered.
+func writePage(w http.ResponseWriter, r *http.Request, code int, page string, context interface{}) error {
+	var b bytes.Buffer
+	if err := executeTemplate(&b, r, page, context); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(code)
 	_, _ = b.WriteTo(w)
+	return nil
+}
+
+// renderStd renders a page describing given status code. Clients that prefer
+// JSON get a problem document.
+func renderStd(w http.ResponseWriter, r *http.Request, code int) {
+	renderFail(w, r, code, "")
+}
+
+// renderFail renders a page describing why the request failed. The
+// description is translated to the language of the client and formatted with
+// given arguments. It can be empty. Clients that prefer JSON get a problem
+// document, unless the code does not describe an error.
+func renderFail(w http.ResponseWriter, r *http.Request, code int, description string, args ...interface{}) {
+	if code >= 400 && wantsJSON(w, r) {
+		writeProblem(w, r, code, description, args...)
+		return
+	}
+	render(w, r, code, statusPage(code), newStatusView(r, code, description, args...))
+}
+
+// statusView describes the outcome of a request that has no page of its own,
+// usually a failure.
+type statusView struct {
+	Code int `json:"code"`
+	// Title is the translated status text.
+	Title string `json:"title"`
+	// Description is the translated explanation of the outcome. It can be
+	// empty.
+	Description string `json:"description,omitempty"`
+	// RequestID allows to find the request in logs.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func newStatusView(r *http.Request, code int, description string, args ...interface{}) statusView {
+	locale := i18n.FromContext(r.Context())
+	view := statusView{
+		Code:      code,
+		Title:     locale.T(http.StatusText(code)),
+		RequestID: logging.RequestID(r.Context()),
+	}
+	if description != "" {
+		view.Description = locale.T(description, args...)
+	}
+	return view
+}
+
+// statusPage returns the name of the page presenting given status code. Each
+// class of errors has its own page.
+func statusPage(code int) string {
+	switch {
+	case code >= 500:
+		return "error-5xx"
+	case code >= 400:
+		return "error-4xx"
+	default:
+		return "status"
+	}
+}
+
+// allowMethods returns true if the request method is one of given methods.
+// Otherwise it responds with 405 Method Not Allowed and returns false.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	renderStd(w, r, http.StatusMethodNotAllowed)
+	return false
+}
+
+// executeTemplate renders the page with functions bound to the given request.
+func executeTemplate(w io.Writer, r *http.Request, page string, context interface{}) error {
+	return requestTemplates(r.Context()).ExecutePage(w, r, page, context)
+}
+
+// executePartial writes the partial with given name, without the layout.
+func executePartial(w io.Writer, r *http.Request, partial string, context interface{}) error {
+	return requestTemplates(r.Context()).ExecutePartial(w, r, partial, context)
+}
+
+// requestFuncs returns template functions that provide information about the
+// request given state is bound to. Templates are parsed with parseFuncs, which
+// declare the same functions.
+func requestFuncs(s *renderState) template.FuncMap {
+	funcs := template.FuncMap{
+		"csrfToken":  func() string { return CSRFToken(s.r.Context()) },
+		"cspNonce":   func() string { return CSPNonce(s.r.Context()) },
+		"profile":    func(accountID string) *Profile { return requestProfile(s.r.Context(), accountID) },
+		"viewer":     func() *Profile { return viewerProfile(s.r.Context()) },
+		"requestURI": func() string { return s.r.RequestURI },
+	}
+	loc := func() *time.Location { return s.loc }
+	locale := func() *i18n.Locale { return s.locale }
+	for name, fn := range timeFuncs(loc, time.Now, locale) {
+		funcs[name] = fn
+	}
+	for name, fn := range translationFuncs(locale) {
+		funcs[name] = fn
+	}
+	return funcs
 }
 
-var (
-	//go:embed template.html
-	tmplRaw string
-	tmpl    = template.Must(template.New("").Parse(tmplRaw))
+// templateFuncs are template functions that do not depend on the request.
+var templateFuncs = template.FuncMap{
+	"static": statics.URL,
+	"contentCharClasses": func() contentCharClasses {
+		return charClasses
+	},
+}
+
+type contextKey int
+
+const (
+	csrfTokenContextKey contextKey = iota
+	cspNonceContextKey
+	accessLogEntryContextKey
+	profilesContextKey
+	viewerProfileContextKey
+	templatesContextKey
 )
@@ -0,0 +1,135 @@
+package plopper
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/husio/plopper/lith"
+	"github.com/husio/plopper/logging"
+)
+
+const followsPerPage = 100
+
+type authorView struct {
+	AuthorID string `json:"author_id"`
+	// Account is the authenticated account. It is nil for anonymous
+	// clients. The session is never serialized.
+	Account    *lith.AccountSession `json:"-"`
+	IsFollowed bool                 `json:"is_followed"`
+	Followers  []string             `json:"followers"`
+	Following  []string             `json:"following"`
+	Plops      []*Plop              `json:"plops"`
+	IsNewest   bool                 `json:"is_newest"`
+	NextPage   string               `json:"next_page,omitempty"`
+}
+
+type authorHandler struct {
+	plops    PlopStore
+	profiles *profileResolver
+	conf     *Settings
+}
+
+func (h *authorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "HEAD") {
+		return
+	}
+	authorID := r.URL.Path
+	if authorID == "" || strings.Contains(authorID, "/") {
+		renderStd(w, r, http.StatusNotFound)
+		return
+	}
+	olderThan, olderThanID, isNewest := paginationCursor(r)
+
+	q := PlopQuery{
+		OlderThan:   olderThan,
+		OlderThanID: olderThanID,
+		AuthorID:    authorID,
+		Statuses:    []PlopStatus{PlopPublished},
+		Limit:       h.conf.PlopsPerPage,
+	}
+	if h.conf.isModerator(r.Context()) {
+		q.Statuses = append(q.Statuses, PlopHidden)
+	}
+	plops, err := h.plops.ListPlops(r.Context(), q)
+	if err != nil {
+		logging.Errorf(r.Context(), "cannot list plops of %s: %s", authorID, err)
+		renderStd(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	followers, err := h.plops.ListFollowers(r.Context(), authorID, followsPerPage)
+	if err != nil {
+		logging.Errorf(r.Context(), "cannot list followers of %s: %s", authorID, err)
+		renderStd(w, r, http.StatusInternalServerError)
+		return
+	}
+	following, err := h.plops.ListFollowing(r.Context(), authorID, followsPerPage)
+	if err != nil {
+		logging.Errorf(r.Context(), "cannot list following of %s: %s", authorID, err)
+		renderStd(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	account, _ := lith.CurrentAccount(r.Context())
+	var isFollowed bool
+	if account != nil {
+		isFollowed, err = h.plops.IsFollowing(r.Context(), account.AccountID, authorID)
+		if err != nil {
+			logging.Errorf(r.Context(), "cannot check if %s follows %s: %s", account.AccountID, authorID, err)
+			renderStd(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	accounts := append([]string{authorID}, plopAuthors(plops)...)
+	accounts = append(accounts, followers...)
+	accounts = append(accounts, following...)
+	r = h.profiles.withProfiles(r, accounts)
+
+	render(w, r, http.StatusOK, "author", authorView{
+		AuthorID:   authorID,
+		Account:    account,
+		IsFollowed: isFollowed,
+		Followers:  followers,
+		Following:  following,
+		Plops:      plops,
+		IsNewest:   isNewest,
+		NextPage:   nextPageCursor(plops, h.conf.PlopsPerPage),
+	})
+}
+
+type followHandler struct {
+	plops PlopStore
+}
+
+func (h *followHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "POST") {
+		return
+	}
+	account, ok := lith.CurrentAccount(r.Context())
+	if !ok {
+		renderFail(w, r, http.StatusUnauthorized, "Not logged in.")
+		return
+	}
+
+	authorID := strings.TrimSpace(r.PostFormValue("account"))
+	if authorID == "" || authorID == account.AccountID {
+		renderFail(w, r, http.StatusBadRequest, "You can follow only other accounts.")
+		return
+	}
+
+	var err error
+	if r.PostFormValue("op") == "unfollow" {
+		err = h.plops.Unfollow(r.Context(), account.AccountID, authorID)
+	} else {
+		err = h.plops.Follow(r.Context(), account.AccountID, authorID)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logging.Errorf(r.Context(), "cannot change follow of %s by %s: %s", authorID, account.AccountID, err)
+		renderStd(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/author/"+url.PathEscape(authorID), http.StatusSeeOther)
+}
@@ -0,0 +1,241 @@
+package plopper
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/husio/plopper/lith"
+	"github.com/husio/plopper/logging"
+)
+
+func newModerationHandler(plops PlopStore, profiles *profileResolver, conf *Settings) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", &moderationQueueHandler{plops: plops, profiles: profiles, conf: conf})
+	mux.Handle("/reports", &moderationReportsHandler{plops: plops, profiles: profiles, conf: conf})
+	mux.Handle("/log", &moderationLogHandler{plops: plops, profiles: profiles, conf: conf})
+	mux.Handle("/act", &moderationActHandler{plops: plops})
+	return mux
+}
+
+type moderationQueueHandler struct {
+	plops    PlopStore
+	profiles *profileResolver
+	conf     *Settings
+}
+
+func (h *moderationQueueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		renderStd(w, r, http.StatusNotFound)
+		return
+	}
+	if !allowMethods(w, r, "GET", "HEAD") {
+		return
+	}
+
+	plops, err := h.plops.ListPlops(r.Context(), PlopQuery{
+		Statuses: []PlopStatus{PlopHeld},
+		Limit:    h.conf.PlopsPerPage,
+	})
+	if err != nil {
+		logging.Errorf(r.Context(), "cannot list held plops: %s", err)
+		renderStd(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	r = h.profiles.withProfiles(r, plopAuthors(plops))
+	render(w, r, http.StatusOK, "moderation-queue", struct {
+		Plops []*Plop `json:"plops"`
+	}{
+		Plops: plops,
+	})
+}
+
+type moderationReportsHandler struct {
+	plops    PlopStore
+	profiles *profileResolver
+	conf     *Settings
+}
+
+func (h *moderationReportsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "HEAD") {
+		return
+	}
+	reports, err := h.plops.ListOpenReports(r.Context(), h.conf.PlopsPerPage)
+	if err != nil {
+		logging.Errorf(r.Context(), "cannot list open reports: %s", err)
+		renderStd(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	accounts := make([]string, 0, 2*len(reports))
+	for _, rep := range reports {
+		accounts = append(accounts, rep.Plop.AuthorID, rep.ReporterID)
+	}
+	r = h.profiles.withProfiles(r, accounts)
+	render(w, r, http.StatusOK, "moderation-reports", struct {
+		Reports []*Report `json:"reports"`
+	}{
+		Reports: reports,
+	})
+}
+
+type moderationLogHandler struct {
+	plops    PlopStore
+	profiles *profileResolver
+	conf     *Settings
+}
+
+func (h *moderationLogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "HEAD") {
+		return
+	}
+	actions, err := h.plops.ListModerationActions(r.Context(), h.conf.PlopsPerPage)
+	if err != nil {
+		logging.Errorf(r.Context(), "cannot list moderation actions: %s", err)
+		renderStd(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	accounts := make([]string, 0, 2*len(actions))
+	for _, a := range actions {
+		accounts = append(accounts, a.ModeratorID, a.AuthorID)
+	}
+	r = h.profiles.withProfiles(r, accounts)
+	render(w, r, http.StatusOK, "moderation-log", struct {
+		Actions []*ModerationAction `json:"actions"`
+	}{
+		Actions: actions,
+	})
+}
+
+type moderationActHandler struct {
+	plops PlopStore
+}
+
+func (h *moderationActHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "POST") {
+		return
+	}
+	account, ok := lith.CurrentAccount(r.Context())
+	if !ok {
+		renderFail(w, r, http.StatusUnauthorized, "Not logged in.")
+		return
+	}
+
+	id, err := hex.DecodeString(r.PostFormValue("plop"))
+	if err != nil {
+		renderFail(w, r, http.StatusBadRequest, "Invalid plop ID.")
+		return
+	}
+	action := ModerationActionType(r.PostFormValue("action"))
+	if !action.Valid() {
+		renderFail(w, r, http.StatusBadRequest, "Unknown moderation action.")
+		return
+	}
+
+	err = h.plops.ApplyModerationAction(r.Context(), &ModerationAction{
+		PlopID:      id,
+		ModeratorID: account.AccountID,
+		Action:      action,
+		Note:        strings.TrimSpace(r.PostFormValue("note")),
+	})
+	switch {
+	case err == nil:
+		http.Redirect(w, r, moderationNext(r), http.StatusSeeOther)
+	case errors.Is(err, ErrNotFound):
+		renderStd(w, r, http.StatusNotFound)
+	default:
+		logging.Errorf(r.Context(), "cannot apply %s moderation action to plop %s: %s", action, PlopID(id), err)
+		renderStd(w, r, http.StatusInternalServerError)
+	}
+}
+
+// moderationNext returns the moderation page that the client should be
+// redirected to. Only moderation pages are allowed, to avoid an open
+// redirect.
+func moderationNext(r *http.Request) string {
+	if next := r.PostFormValue("next"); strings.HasPrefix(next, "/moderation/") {
+		return next
+	}
+	return "/moderation/"
+}
+
+type reportPlopHandler struct {
+	plops    PlopStore
+	profiles *profileResolver
+	conf     *Settings
+}
+
+// maxReportReasonLength limits the length of the report reason, in user
+// perceived characters.
+const maxReportReasonLength = 1024
+
+// reportReasonLimits describe what report reasons are accepted. A reason is
+// normalized and measured like plop content.
+func (s *Settings) reportReasonLimits() contentLimits {
+	return contentLimits{
+		MinLength:   1,
+		MaxLength:   maxReportReasonLength,
+		MaxNewlines: s.MaxNewlines,
+	}
+}
+
+// reportPlopView is the report form of a plop.
+type reportPlopView struct {
+	Plop         *Plop         `json:"plop"`
+	ReasonLimits contentLimits `json:"reason_limits"`
+}
+
+func (h *reportPlopHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	account, ok := lith.CurrentAccount(r.Context())
+	if !ok {
+		renderFail(w, r, http.StatusUnauthorized, "Not logged in.")
+		return
+	}
+	if !allowMethods(w, r, "GET", "HEAD", "POST") {
+		return
+	}
+
+	id, err := hex.DecodeString(r.URL.Path)
+	if err != nil {
+		renderStd(w, r, http.StatusNotFound)
+		return
+	}
+	plop, err := h.plops.Plop(r.Context(), id)
+	switch {
+	case err == nil:
+		// All good.
+	case errors.Is(err, ErrNotFound):
+		renderStd(w, r, http.StatusNotFound)
+		return
+	default:
+		logging.Errorf(r.Context(), "cannot get plop %q: %s", id, err)
+		renderStd(w, r, http.StatusInternalServerError)
+		return
+	}
+	if plop.Status != PlopPublished {
+		renderStd(w, r, http.StatusNotFound)
+		return
+	}
+
+	if r.Method != "POST" {
+		r = h.profiles.withProfiles(r, []string{plop.AuthorID})
+		render(w, r, http.StatusOK, "report-plop", reportPlopView{Plop: plop, ReasonLimits: h.conf.reportReasonLimits()})
+		return
+	}
+
+	limits := h.conf.reportReasonLimits()
+	reason := normalizeContent(r.PostFormValue("reason"), limits.MaxNewlines)
+	if n := graphemeCount(reason); n < limits.MinLength || n > limits.MaxLength {
+		renderFail(w, r, http.StatusBadRequest, "Reason must be provided and must not exceed %d characters.", limits.MaxLength)
+		return
+	}
+	if err := h.plops.CreateReport(r.Context(), id, account.AccountID, reason); err != nil {
+		logging.Errorf(r.Context(), "cannot report plop %s: %s", plop.ID, err)
+		renderStd(w, r, http.StatusInternalServerError)
+		return
+	}
+	renderFail(w, r, http.StatusAccepted, "Thank you. Moderators will review your report.")
+}
@@ -0,0 +1,135 @@
+package plopper
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/husio/plopper/lith"
+	"github.com/husio/plopper/logging"
+)
+
+type relationsHandler struct {
+	plops PlopStore
+}
+
+func (h *relationsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	account, ok := lith.CurrentAccount(r.Context())
+	if !ok {
+		renderFail(w, r, http.StatusUnauthorized, "Not logged in.")
+		return
+	}
+	if !allowMethods(w, r, "GET", "HEAD", "POST") {
+		return
+	}
+
+	if r.Method == "POST" {
+		targetID := strings.TrimSpace(r.PostFormValue("account"))
+		kind := RelationKind(r.PostFormValue("kind"))
+		if targetID == "" || !kind.Valid() {
+			renderFail(w, r, http.StatusBadRequest, "Account and a valid relation kind must be provided.")
+			return
+		}
+		if targetID == account.AccountID {
+			renderFail(w, r, http.StatusBadRequest, "You cannot mute or block yourself.")
+			return
+		}
+
+		var err error
+		if r.PostFormValue("op") == "remove" {
+			err = h.plops.DeleteRelation(r.Context(), account.AccountID, targetID, kind)
+		} else {
+			err = h.plops.CreateRelation(r.Context(), account.AccountID, targetID, kind)
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			logging.Errorf(r.Context(), "cannot change %s relation of %s: %s", kind, account.AccountID, err)
+			renderStd(w, r, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/relations/", http.StatusSeeOther)
+		return
+	}
+
+	relations, err := h.plops.ListRelations(r.Context(), account.AccountID)
+	if err != nil {
+		logging.Errorf(r.Context(), "cannot list relations of %s: %s", account.AccountID, err)
+		renderStd(w, r, http.StatusInternalServerError)
+		return
+	}
+	render(w, r, http.StatusOK, "relations", struct {
+		Relations []*Relation `json:"relations"`
+	}{
+		Relations: relations,
+	})
+}
+
+// relationsAPIHandler allows to manage relations of the authenticated account
+// using JSON API.
+//
+//	GET    /api/relations                           list all relations
+//	POST   /api/relations {"account_id", "kind"}    create a relation
+//	DELETE /api/relations?account_id=..&kind=..     delete a relation
+type relationsAPIHandler struct {
+	plops PlopStore
+}
+
+type relationJSON struct {
+	AccountID string       `json:"account_id"`
+	Kind      RelationKind `json:"kind"`
+}
+
+func (h *relationsAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	account, ok := lith.CurrentAccount(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "Not logged in.")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		relations, err := h.plops.ListRelations(r.Context(), account.AccountID)
+		if err != nil {
+			logging.Errorf(r.Context(), "cannot list relations of %s: %s", account.AccountID, err)
+			writeProblem(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		resp := make([]relationJSON, 0, len(relations))
+		for _, rel := range relations {
+			resp = append(resp, relationJSON{AccountID: rel.TargetID, Kind: rel.Kind})
+		}
+		writeJSON(w, r, http.StatusOK, resp)
+	case "POST":
+		var input relationJSON
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1e4)).Decode(&input); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "Invalid JSON body.")
+			return
+		}
+		if input.AccountID == "" || input.AccountID == account.AccountID || !input.Kind.Valid() {
+			writeProblem(w, r, http.StatusBadRequest, "Account and a valid relation kind must be provided.")
+			return
+		}
+		if err := h.plops.CreateRelation(r.Context(), account.AccountID, input.AccountID, input.Kind); err != nil {
+			logging.Errorf(r.Context(), "cannot create %s relation of %s: %s", input.Kind, account.AccountID, err)
+			writeProblem(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, input)
+	case "DELETE":
+		query := r.URL.Query()
+		kind := RelationKind(query.Get("kind"))
+		switch err := h.plops.DeleteRelation(r.Context(), account.AccountID, query.Get("account_id"), kind); {
+		case err == nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, ErrNotFound):
+			writeProblem(w, r, http.StatusNotFound, "")
+		default:
+			logging.Errorf(r.Context(), "cannot delete %s relation of %s: %s", kind, account.AccountID, err)
+			writeProblem(w, r, http.StatusInternalServerError, "")
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+	}
+}
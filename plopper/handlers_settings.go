@@ -0,0 +1,132 @@
+package plopper
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/husio/plopper/i18n"
+	"github.com/husio/plopper/lith"
+	"github.com/husio/plopper/logging"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 280
+)
+
+type settingsHandler struct {
+	plops    PlopStore
+	profiles *profileResolver
+}
+
+func (h *settingsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	account, ok := lith.CurrentAccount(r.Context())
+	if !ok {
+		renderFail(w, r, http.StatusUnauthorized, "Not logged in.")
+		return
+	}
+
+	if !allowMethods(w, r, "GET", "HEAD", "POST") {
+		return
+	}
+	profile := *viewerProfile(r.Context())
+	if r.Method != "POST" {
+		render(w, r, http.StatusOK, "settings", settingsView{
+			Profile: &profile,
+			Saved:   r.URL.Query().Get("saved") != "",
+		})
+		return
+	}
+
+	profile.AccountID = account.AccountID
+	profile.DisplayName = strings.TrimSpace(r.PostFormValue("display_name"))
+	profile.Bio = strings.TrimSpace(r.PostFormValue("bio"))
+	profile.Timezone = strings.TrimSpace(r.PostFormValue("timezone"))
+	profile.Theme = r.PostFormValue("theme")
+	profile.DefaultTimeline = r.PostFormValue("default_timeline")
+	if errs := validateProfile(i18n.FromContext(r.Context()), &profile); len(errs) != 0 {
+		render(w, r, http.StatusBadRequest, "settings", settingsView{
+			Profile: &profile,
+			Errors:  errs,
+		})
+		return
+	}
+
+	if err := h.plops.SaveProfile(r.Context(), &profile); err != nil {
+		logging.Errorf(r.Context(), "cannot save profile of %s: %s", account.AccountID, err)
+		renderStd(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.profiles.Forget(account.AccountID)
+	http.Redirect(w, r, "/settings?saved=1", http.StatusSeeOther)
+}
+
+type settingsView struct {
+	Profile *Profile `json:"profile"`
+	// Errors maps form field names to the validation error of the field.
+	Errors map[string]string `json:"errors,omitempty"`
+	Saved  bool              `json:"saved"`
+}
+
+// validateProfile returns validation errors of profile fields that can be
+// changed by the account owner, by form field name. Errors are translated
+// using given locale.
+func validateProfile(l *i18n.Locale, p *Profile) map[string]string {
+	errs := make(map[string]string)
+	if utf8.RuneCountInString(p.DisplayName) > maxDisplayNameLength {
+		errs["display_name"] = l.T("Display name must not be longer than %d characters.", maxDisplayNameLength)
+	}
+	if utf8.RuneCountInString(p.Bio) > maxBioLength {
+		errs["bio"] = l.T("Bio must not be longer than %d characters.", maxBioLength)
+	}
+	if p.Timezone != "" {
+		if _, ok := loadLocation(p.Timezone); !ok {
+			errs["timezone"] = l.T("Unknown time zone. Use a name like Europe/Warsaw.")
+		}
+	}
+	switch p.Theme {
+	case "", themeLight, themeDark:
+	default:
+		errs["theme"] = l.T("Unknown theme.")
+	}
+	switch p.DefaultTimeline {
+	case "", timelineGlobal, timelineFollowing:
+	default:
+		errs["default_timeline"] = l.T("Unknown timeline.")
+	}
+	return errs
+}
+
+// viewerProfileMiddleware loads the local profile of the authenticated account
+// into the context. It must be wrapped by lith.AuthMiddleware.
+type viewerProfileMiddleware struct {
+	plops PlopStore
+	next  http.Handler
+}
+
+func (m *viewerProfileMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if account, ok := lith.CurrentAccount(r.Context()); ok {
+		profile := &Profile{AccountID: account.AccountID}
+		switch profiles, err := m.plops.Profiles(r.Context(), []string{account.AccountID}); {
+		case err != nil:
+			// Preferences are not essential to serve the request.
+			logging.Errorf(r.Context(), "cannot load profile of %s: %s", account.AccountID, err)
+		case profiles[account.AccountID] != nil:
+			profile = profiles[account.AccountID]
+		}
+		ctx := context.WithValue(r.Context(), viewerProfileContextKey, profile)
+		r = r.WithContext(ctx)
+	}
+	m.next.ServeHTTP(w, r)
+}
+
+// viewerProfile returns the local profile of the authenticated account. An
+// empty profile is returned for anonymous clients.
+func viewerProfile(ctx context.Context) *Profile {
+	if p, ok := ctx.Value(viewerProfileContextKey).(*Profile); ok {
+		return p
+	}
+	return &Profile{}
+}
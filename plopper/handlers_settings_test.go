@@ -0,0 +1,106 @@
+package plopper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/husio/plopper/lith"
+)
+
+type fakeIntrospector map[string]*lith.AccountSession
+
+func (f fakeIntrospector) SessionIntrospect(ctx context.Context, token string) (*lith.AccountSession, error) {
+	if a, ok := f[token]; ok {
+		return a, nil
+	}
+	return nil, lith.ErrUnauthorized
+}
+
+func TestSettingsHandler(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+	createdAt := time.Date(2022, 1, 1, 20, 0, 0, 0, time.UTC)
+	if _, err := store.InsertPlops(ctx, []*Plop{
+		{ID: newPlopID(), AuthorID: "alice", CreatedAt: createdAt, Content: "late plop", Status: PlopPublished},
+	}); err != nil {
+		t.Fatalf("cannot insert plop: %s", err)
+	}
+
+	conf := DefaultSettings()
+	profiles := newProfileResolver(&fakeDirectory{}, store)
+	mux := http.NewServeMux()
+	mux.Handle("/settings", &settingsHandler{plops: store, profiles: profiles})
+	mux.Handle("/author/", http.StripPrefix("/author/", &authorHandler{plops: store, profiles: profiles, conf: &conf}))
+	handler := lith.AuthMiddleware(fakeIntrospector{
+		"alice-session": {AccountID: "alice"},
+	})(&viewerProfileMiddleware{plops: store, next: mux})
+
+	serve := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "Bearer alice-session")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Resolved profiles are cached, so an earlier lookup must not hide
+	// the change.
+	_ = profiles.Resolve(ctx, []string{"alice"})
+
+	w := serve("POST", "/settings", url.Values{"timezone": {"Mars/Olympus"}, "theme": {"dark"}})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("want invalid time zone rejected, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Unknown time zone") {
+		t.Fatalf("want time zone error shown, got %s", w.Body)
+	}
+
+	w = serve("POST", "/settings", url.Values{
+		"display_name":     {"  Alice A.  "},
+		"bio":              {"Writes plops at night."},
+		"timezone":         {"Asia/Tokyo"},
+		"theme":            {"dark"},
+		"default_timeline": {"following"},
+	})
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/settings?saved=1" {
+		t.Fatalf("want redirect after save, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	saved, err := store.Profiles(ctx, []string{"alice"})
+	if err != nil {
+		t.Fatalf("cannot load profile: %s", err)
+	}
+	p := saved["alice"]
+	if p == nil || p.DisplayName != "Alice A." || p.Bio != "Writes plops at night." || p.Timezone != "Asia/Tokyo" || p.Theme != "dark" || p.DefaultTimeline != "following" {
+		t.Fatalf("unexpected saved profile: %+v", p)
+	}
+
+	w = serve("GET", "/author/alice", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want author page, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`data-theme="dark"`,
+		"Alice A.",
+		"Writes plops at night.",
+		// 20:00 UTC is already the next day in Tokyo.
+		"2 Jan 2022",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q in the author page", want)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package plopper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/husio/plopper/lith"
+	"github.com/husio/plopper/logging"
+)
+
+func TestStatusCodes(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+
+	published := PlopID(bytes.Repeat([]byte{0xaa}, 16))
+	held := PlopID(bytes.Repeat([]byte{0xbb}, 16))
+	missing := strings.Repeat("ff", 16)
+	now := time.Now().UTC()
+	if _, err := store.InsertPlops(ctx, []*Plop{
+		{ID: published, AuthorID: "alice", CreatedAt: now, Content: "published plop", Status: PlopPublished},
+		{ID: held, AuthorID: "alice", CreatedAt: now, Content: "held plop", Status: PlopHeld},
+	}); err != nil {
+		t.Fatalf("cannot insert plops: %s", err)
+	}
+
+	handler := newTestApplication(t, store, DefaultSettings())
+
+	cases := map[string]struct {
+		method  string
+		path    string
+		session string
+		form    url.Values
+		want    int
+		// wantAllow is the expected Allow header of 405 responses.
+		wantAllow string
+		// inline is true if the failure is described within the requested
+		// page instead of an error page.
+		inline bool
+	}{
+		"list":                    {method: "GET", path: "/", want: http.StatusOK},
+		"unknown path":            {method: "GET", path: "/nope", want: http.StatusNotFound},
+		"plop":                    {method: "GET", path: "/plop/" + published.String(), want: http.StatusOK},
+		"unknown plop":            {method: "GET", path: "/plop/" + missing, want: http.StatusNotFound},
+		"held plop":               {method: "GET", path: "/plop/" + held.String(), want: http.StatusNotFound},
+		"held plop for moderator": {method: "GET", path: "/plop/" + held.String(), session: "mod", want: http.StatusOK},
+		"bad hex plop ID":         {method: "GET", path: "/plop/zz", want: http.StatusNotFound},
+		"short plop ID":           {method: "GET", path: "/plop/abcd", want: http.StatusNotFound},
+		"plop method":             {method: "POST", path: "/plop/" + published.String(), session: "alice", want: http.StatusMethodNotAllowed, wantAllow: "GET, HEAD"},
+		"list method":             {method: "DELETE", path: "/", session: "alice", want: http.StatusMethodNotAllowed, wantAllow: "GET, HEAD"},
+		"author":                  {method: "GET", path: "/author/alice", want: http.StatusOK},
+		"no author":               {method: "GET", path: "/author/", want: http.StatusNotFound},
+		"author method":           {method: "PUT", path: "/author/alice", session: "alice", want: http.StatusMethodNotAllowed, wantAllow: "GET, HEAD"},
+		"report form":             {method: "GET", path: "/report/" + published.String(), session: "alice", want: http.StatusOK},
+		"report unknown plop":     {method: "GET", path: "/report/" + missing, session: "alice", want: http.StatusNotFound},
+		"report bad hex plop ID":  {method: "GET", path: "/report/zz", session: "alice", want: http.StatusNotFound},
+		"report method":           {method: "PUT", path: "/report/" + published.String(), session: "alice", want: http.StatusMethodNotAllowed, wantAllow: "GET, HEAD, POST"},
+		"report accepted":         {method: "POST", path: "/report/" + published.String(), session: "alice", form: url.Values{"reason": {"spam"}}, want: http.StatusAccepted},
+		"report long reason":      {method: "POST", path: "/report/" + published.String(), session: "alice", form: url.Values{"reason": {strings.Repeat("ż", 1024)}}, want: http.StatusAccepted},
+		"report too long reason":  {method: "POST", path: "/report/" + published.String(), session: "alice", form: url.Values{"reason": {strings.Repeat("ż", 1025)}}, want: http.StatusBadRequest},
+		"report emoji reason":     {method: "POST", path: "/report/" + published.String(), session: "alice", form: url.Values{"reason": {strings.Repeat("\U0001F44D\U0001F3FD", 1024)}}, want: http.StatusAccepted},
+		"report empty reason":     {method: "POST", path: "/report/" + published.String(), session: "alice", form: url.Values{"reason": {" \u200b "}}, want: http.StatusBadRequest},
+		"follow method":           {method: "GET", path: "/follow", session: "alice", want: http.StatusMethodNotAllowed, wantAllow: "POST"},
+		"language method":         {method: "GET", path: "/language", want: http.StatusMethodNotAllowed, wantAllow: "POST"},
+		"settings":                {method: "GET", path: "/settings", session: "alice", want: http.StatusOK},
+		"settings invalid":        {method: "POST", path: "/settings", session: "alice", form: url.Values{"theme": {"pink"}}, want: http.StatusBadRequest, inline: true},
+		"settings method":         {method: "DELETE", path: "/settings", session: "alice", want: http.StatusMethodNotAllowed, wantAllow: "GET, HEAD, POST"},
+		"settings anonymous":      {method: "GET", path: "/settings", want: http.StatusSeeOther},
+		"relations method":        {method: "PUT", path: "/relations/", session: "alice", want: http.StatusMethodNotAllowed, wantAllow: "GET, HEAD, POST"},
+		"relations API method":    {method: "PUT", path: "/api/relations", session: "alice", want: http.StatusMethodNotAllowed, wantAllow: "GET, POST, DELETE"},
+		"relations API anonymous": {method: "GET", path: "/api/relations", want: http.StatusUnauthorized},
+		"moderation permission":   {method: "GET", path: "/moderation/", session: "alice", want: http.StatusForbidden},
+		"moderation queue":        {method: "GET", path: "/moderation/", session: "mod", want: http.StatusOK},
+		"moderation unknown":      {method: "GET", path: "/moderation/nope", session: "mod", want: http.StatusNotFound},
+		"moderation act method":   {method: "GET", path: "/moderation/act", session: "mod", want: http.StatusMethodNotAllowed, wantAllow: "POST"},
+		"create without CSRF":     {method: "POST", path: "/create", want: http.StatusForbidden},
+		"unknown static file":     {method: "GET", path: "/static/nope.css", want: http.StatusNotFound},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.session != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.session)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tc.want {
+				t.Fatalf("want %d, got %d:\n%s", tc.want, w.Code, w.Body)
+			}
+			if allow := w.Header().Get("Allow"); allow != tc.wantAllow {
+				t.Fatalf("want %q allowed methods, got %q", tc.wantAllow, allow)
+			}
+			if tc.want < 400 || tc.inline {
+				return
+			}
+
+			body := w.Body.String()
+			if tc.path == "/api/relations" {
+				if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+					t.Fatalf("want a problem document, got %q", ct)
+				}
+				return
+			}
+			if want := fmt.Sprintf("<h1>%d %s</h1>", tc.want, http.StatusText(tc.want)); !strings.Contains(body, want) {
+				t.Fatalf("want %q in the error page:\n%s", want, body)
+			}
+			if want := `class="error client-error"`; !strings.Contains(body, want) {
+				t.Fatalf("want %q in the error page:\n%s", want, body)
+			}
+			if id := w.Header().Get("X-Request-ID"); id == "" || !strings.Contains(body, "<code>"+id+"</code>") {
+				t.Fatalf("want %q request ID in the error page:\n%s", id, body)
+			}
+		})
+	}
+}
+
+func TestCreatePlop(t *testing.T) {
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+	handler := newTestApplication(t, store, DefaultSettings())
+
+	create := func(content, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/create", strings.NewReader(url.Values{"content": {content}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "Bearer alice")
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("invalid form", func(t *testing.T) {
+		w := create("<x", "text/html")
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("want 400, got %d", w.Code)
+		}
+		// The list page is displayed with the submitted content kept.
+		body := w.Body.String()
+		for _, want := range []string{
+			`aria-invalid="true">&lt;x</textarea>`,
+			`role="alert">Content must be at least 3 characters.</small>`,
+			`<p class="no-plops">`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Fatalf("want %q in the page:\n%s", want, body)
+			}
+		}
+	})
+
+	t.Run("invalid script", func(t *testing.T) {
+		w := create("<x", "application/json")
+		if ct := w.Header().Get("Content-Type"); w.Code != http.StatusBadRequest || ct != "application/problem+json" {
+			t.Fatalf("want 400 problem document, got %d %q", w.Code, ct)
+		}
+		var p problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Detail != "Content must be at least 3 characters." {
+			t.Fatalf("unexpected problem: %+v", p)
+		}
+	})
+
+	t.Run("form", func(t *testing.T) {
+		w := create("from a form", "text/html")
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+			t.Fatalf("want redirect to the list, got %d %q", w.Code, w.Header().Get("Location"))
+		}
+	})
+
+	t.Run("script", func(t *testing.T) {
+		w := create("from a <script>", "application/json")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("want 201, got %d:\n%s", w.Code, w.Body)
+		}
+		var got struct {
+			Plop struct {
+				ID      string `json:"id"`
+				Content string `json:"content"`
+			} `json:"plop"`
+			HTML string `json:"html"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if loc := w.Header().Get("Location"); loc != "/plop/"+got.Plop.ID {
+			t.Fatalf("unexpected location %q of %s", loc, got.Plop.ID)
+		}
+		if !strings.Contains(got.HTML, `id="plop-`+got.Plop.ID+`"`) || !strings.Contains(got.HTML, "from a &lt;script&gt;") {
+			t.Fatalf("unexpected plop HTML:\n%s", got.HTML)
+		}
+		if strings.Contains(got.HTML, "<footer>") {
+			t.Fatalf("plop HTML must not include the layout:\n%s", got.HTML)
+		}
+	})
+}
+
+func TestModerationLinks(t *testing.T) {
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+	conf := DefaultSettings()
+	conf.ModeratePermission = "custom:moderate"
+	handler := newTestApplication(t, store, conf)
+
+	for session, want := range map[string]bool{"alice": false, "mod": true} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+session)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: want 200, got %d", session, w.Code)
+		}
+		if got := strings.Contains(w.Body.String(), `href="/moderation/"`); got != want {
+			t.Errorf("%s: want moderation link %v, got %v", session, want, got)
+		}
+	}
+}
+
+func TestReportFormLimits(t *testing.T) {
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+	handler := newTestApplication(t, store, DefaultSettings())
+	id, err := store.Create(context.Background(), "bob", "hello", PlopPublished)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/report/"+id.String(), nil)
+	r.Header.Set("Authorization", "Bearer alice")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	// The length is enforced by the script, which counts characters the
+	// way the server does.
+	for _, want := range []string{`data-max-length="1024"`, `data-space="`, `data-counter="reason-info"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q in the report form:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "maxlength=") {
+		t.Errorf("report form limits length in UTF-16 code units:\n%s", body)
+	}
+}
+
+func TestRenderTemplateFailure(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(logging.WithRequestID(r.Context(), "r3qu3st"))
+	w := httptest.NewRecorder()
+
+	// The page expects a different view, so that it fails to execute.
+	render(w, r, http.StatusOK, "settings", "not a settings view")
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("want 500, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{`class="error server-error"`, "<code>r3qu3st</code>", "<!doctype html>"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("want %q in the error page:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `id="display_name"`) {
+		t.Fatalf("partially rendered page must not be written:\n%s", body)
+	}
+}
+
+// newTestApplication returns the application using given store and settings.
+// Requests are authenticated with "Bearer alice" or "Bearer mod" authorization
+// header. The "mod" account is granted the moderate permission from settings.
+func newTestApplication(t *testing.T, store PlopStore, conf Settings) http.Handler {
+	t.Helper()
+
+	// The application registers the account proxy on the default mux,
+	// which allows it only once.
+	defaultMux := http.DefaultServeMux
+	http.DefaultServeMux = http.NewServeMux()
+	t.Cleanup(func() { http.DefaultServeMux = defaultMux })
+
+	sessions := map[string]*lith.AccountSession{
+		"alice": {AccountID: "alice", Permissions: []string{conf.CreatePermission}},
+		"mod":   {AccountID: "mod", Permissions: []string{conf.CreatePermission, conf.ModeratePermission}},
+	}
+	lithSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sessions":
+			session, ok := sessions[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(session)
+		case "/accounts":
+			_, _ = io.WriteString(w, `{"accounts": []}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(lithSrv.Close)
+
+	conf.AuthUI = lithSrv.URL
+	conf.CSRFSecret = []byte("secret")
+	app := NewHTTPApplication(store, lith.NewClient(lithSrv.URL, nil), LogModerator{}, conf)
+	return AccessLogMiddleware(logging.New(io.Discard, logging.Logfmt))(app)
+}
@@ -0,0 +1,139 @@
+package plopper
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/husio/plopper/logging"
+	"github.com/husio/plopper/metrics"
+	"github.com/husio/plopper/tracing"
+)
+
+var (
+	httpRequests = metrics.NewCounter(
+		"plopper_http_requests_total",
+		"Number of handled HTTP requests.",
+		"handler", "code")
+	httpRequestDuration = metrics.NewHistogram(
+		"plopper_http_request_duration_seconds",
+		"Duration of HTTP request handling.",
+		nil, "handler")
+	plopsCreated = metrics.NewCounter(
+		"plopper_plops_created_total",
+		"Number of created plops.",
+		"status")
+)
+
+// instrument returns a handler that measures the number and the duration of
+// requests served by next and traces each request as a separate span. Name
+// identifies the handler in the metrics and in the span.
+func instrument(name string, next http.Handler) http.Handler {
+	return &instrumentedHandler{name: name, next: next}
+}
+
+type instrumentedHandler struct {
+	name string
+	next http.Handler
+}
+
+func (h *instrumentedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx, span := tracing.Start(r.Context(), "handler."+h.name)
+	defer span.End()
+
+	rec := &statusRecorder{ResponseWriter: w}
+	h.next.ServeHTTP(rec, r.WithContext(ctx))
+	span.SetAttribute("http.status_code", rec.Status())
+	httpRequests.Inc(h.name, strconv.Itoa(rec.Status()))
+	httpRequestDuration.Observe(time.Since(start).Seconds(), h.name)
+}
+
+// statusRecorder is an http.ResponseWriter that remembers the response status
+// code.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Status returns the status code of the response. If nothing was written, the
+// server responds with 200.
+func (r *statusRecorder) Status() int {
+	if r.code == 0 {
+		return http.StatusOK
+	}
+	return r.code
+}
+
+// healthzHandler responds with success as long as the process is able to
+// serve HTTP requests.
+type healthzHandler struct{}
+
+func (healthzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyzHandler responds with success only if all dependencies are available.
+// The endpoint is public, so only the outcome of each check is presented and
+// errors are logged.
+type readyzHandler struct {
+	checks map[string]func(context.Context) error
+}
+
+func (h *readyzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(h.checks))
+	for name, check := range h.checks {
+		go func(name string, check func(context.Context) error) {
+			results <- result{name: name, err: check(ctx)}
+		}(name, check)
+	}
+
+	code := http.StatusOK
+	status := make(map[string]string, len(h.checks))
+	for range h.checks {
+		res := <-results
+		if res.err != nil {
+			logging.Errorf(r.Context(), "readiness check %s failed: %s", res.name, res.err)
+			code = http.StatusServiceUnavailable
+			status[res.name] = "fail"
+		} else {
+			status[res.name] = "ok"
+		}
+	}
+
+	names := make([]string, 0, len(status))
+	for name := range status {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("content-type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	for _, name := range names {
+		_, _ = w.Write([]byte(name + ": " + status[name] + "\n"))
+	}
+}
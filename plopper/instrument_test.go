@@ -0,0 +1,24 @@
+package plopper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyzHidesErrors(t *testing.T) {
+	handler := &readyzHandler{checks: map[string]func(context.Context) error{
+		"store": func(context.Context) error { return errors.New("open /var/lib/plopper.sqlite3: permission denied") },
+		"lith":  func(context.Context) error { return nil },
+	}}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want 503, got %d", w.Code)
+	}
+	if want := "lith: ok\nstore: fail\n"; w.Body.String() != want {
+		t.Fatalf("want %q, got %q", want, w.Body.String())
+	}
+}
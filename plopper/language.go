@@ -0,0 +1,98 @@
+package plopper
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/husio/plopper/i18n"
+)
+
+// languageCookie holds the tag of the language explicitly chosen by the
+// client.
+const languageCookie = "lang"
+
+// LanguageMiddleware returns an http.Handler middleware that selects the
+// language of the response. The language chosen using the language switch
+// takes precedence over the Accept-Language header.
+//
+// Within decorated http.Handler, i18n.FromContext function can be called to
+// retrieve the selected locale.
+func LanguageMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &languageMiddleware{next: next}
+	}
+}
+
+type languageMiddleware struct {
+	next http.Handler
+}
+
+func (m *languageMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	locale := requestLocale(r)
+	h := w.Header()
+	h.Set("Content-Language", locale.Tag)
+	h.Add("Vary", "Accept-Language")
+	h.Add("Vary", "Cookie")
+	m.next.ServeHTTP(w, r.WithContext(i18n.NewContext(r.Context(), locale)))
+}
+
+func requestLocale(r *http.Request) *i18n.Locale {
+	if c, err := r.Cookie(languageCookie); err == nil {
+		if l, ok := i18n.Lookup(c.Value); ok {
+			return l
+		}
+	}
+	return i18n.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// languageHandler remembers the language chosen by the client and redirects
+// back to the page the choice was made on.
+type languageHandler struct{}
+
+func (languageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "POST") {
+		return
+	}
+	locale, ok := i18n.Lookup(r.PostFormValue("lang"))
+	if !ok {
+		renderFail(w, r, http.StatusBadRequest, "Unsupported language.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     languageCookie,
+		Value:    locale.Tag,
+		Path:     "/",
+		MaxAge:   365 * 24 * 3600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Only local paths are allowed, to avoid an open redirect.
+	next := r.PostFormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/"
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// translationFuncs returns template functions that translate messages to the
+// language of the locale returned by l.
+func translationFuncs(l func() *i18n.Locale) template.FuncMap {
+	return template.FuncMap{
+		"t": func(msg string, args ...interface{}) string { return l().T(msg, args...) },
+		// th translates a message containing HTML markup. Arguments are
+		// escaped, the message itself is trusted.
+		"th": func(msg string, args ...interface{}) template.HTML {
+			for i, a := range args {
+				args[i] = template.HTMLEscaper(a)
+			}
+			return template.HTML(l().T(msg, args...))
+		},
+		"tn": func(n int, one, other string, args ...interface{}) string {
+			return l().N(n, one, other, args...)
+		},
+		"locale":  func() *i18n.Locale { return l() },
+		"locales": i18n.Locales,
+	}
+}
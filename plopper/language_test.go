@@ -0,0 +1,198 @@
+package plopper
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/husio/plopper/i18n"
+)
+
+// usedMessages returns all messages that are translated by the application,
+// together with information whether the message has plural forms.
+func usedMessages(t *testing.T) map[string]bool {
+	t.Helper()
+	const lit = "(\"(?:[^\"\\\\]|\\\\.)*\"|`[^`]*`)"
+	var (
+		templateT  = regexp.MustCompile(`(?:\{\{|\()(?:t|th)\s+` + lit)
+		templateTN = regexp.MustCompile(`(?:\{\{|\()tn\s+(?:\([^)]*\)|\S+)\s+` + lit)
+		goT        = regexp.MustCompile(`(?:renderFail\(w, r, [\w.]+|writeProblem\(w, r, [\w.]+|\.T\()\s*,?\s*` + lit)
+		goN        = regexp.MustCompile(`\.N\([^,]+,\s*` + lit)
+	)
+
+	messages := make(map[string]bool)
+	collect := func(rx *regexp.Regexp, src string, plural bool) {
+		for _, m := range rx.FindAllStringSubmatch(src, -1) {
+			msg, err := strconv.Unquote(m[1])
+			if err != nil {
+				t.Fatalf("cannot unquote %s: %s", m[1], err)
+			}
+			// An empty description is replaced with the status text.
+			if msg != "" {
+				messages[msg] = plural
+			}
+		}
+	}
+
+	err := fs.WalkDir(templatesRaw, "templates", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(templatesRaw, path)
+		if err != nil {
+			return err
+		}
+		collect(templateT, string(b), false)
+		collect(templateTN, string(b), true)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range sources {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		collect(goT, string(b), false)
+		collect(goN, string(b), true)
+	}
+
+	// Messages that are not literals.
+	for _, msg := range []string{
+		dateFmt, absTimeFmt,
+		string(PlopPublished), string(PlopHeld), string(PlopRejected), string(PlopHidden),
+		string(RelationMute), string(RelationBlock),
+		string(ActionApprove), string(ActionReject), string(ActionDismiss),
+		string(ActionWarn), string(ActionHide), string(ActionDelete),
+	} {
+		messages[msg] = false
+	}
+	for _, code := range []int{
+		http.StatusAccepted,
+		http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden,
+		http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusConflict,
+		http.StatusTooManyRequests, http.StatusInternalServerError,
+	} {
+		messages[http.StatusText(code)] = false
+	}
+	return messages
+}
+
+func TestTranslationsComplete(t *testing.T) {
+	messages := usedMessages(t)
+	if len(messages) < 50 {
+		t.Fatalf("only %d messages found, the extraction is broken", len(messages))
+	}
+	for _, l := range i18n.Locales() {
+		if l == i18n.English {
+			continue
+		}
+		for msg, plural := range messages {
+			if !l.HasMessage(msg, plural) {
+				t.Errorf("%s: missing translation of %q", l.Tag, msg)
+			}
+		}
+	}
+}
+
+func TestLanguageSelection(t *testing.T) {
+	var got string
+	handler := LanguageMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = i18n.FromContext(r.Context()).Tag
+	}))
+
+	cases := map[string]struct {
+		acceptLanguage string
+		cookie         string
+		want           string
+	}{
+		"default":                 {want: "en"},
+		"accept language":         {acceptLanguage: "pl-PL,pl;q=0.9,en;q=0.8", want: "pl"},
+		"unsupported preferred":   {acceptLanguage: "de;q=1,pl;q=0.5", want: "pl"},
+		"cookie over header":      {acceptLanguage: "pl", cookie: "en", want: "en"},
+		"invalid cookie ignored":  {acceptLanguage: "pl", cookie: "xx", want: "pl"},
+		"cookie without a header": {cookie: "pl", want: "pl"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tc.acceptLanguage)
+			}
+			if tc.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: languageCookie, Value: tc.cookie})
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if got != tc.want {
+				t.Fatalf("want %q locale, got %q", tc.want, got)
+			}
+			if h := w.Header().Get("Content-Language"); h != tc.want {
+				t.Fatalf("want %q content language, got %q", tc.want, h)
+			}
+		})
+	}
+}
+
+func TestLanguageSwitch(t *testing.T) {
+	cases := map[string]struct {
+		form       url.Values
+		wantCode   int
+		wantCookie string
+		wantNext   string
+	}{
+		"switch": {
+			form:       url.Values{"lang": {"pl"}, "next": {"/author/bob?before=1"}},
+			wantCode:   http.StatusSeeOther,
+			wantCookie: "pl",
+			wantNext:   "/author/bob?before=1",
+		},
+		"external next": {
+			form:       url.Values{"lang": {"en"}, "next": {"//example.com/"}},
+			wantCode:   http.StatusSeeOther,
+			wantCookie: "en",
+			wantNext:   "/",
+		},
+		"unsupported language": {
+			form:     url.Values{"lang": {"xx"}, "next": {"/"}},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/language", strings.NewReader(tc.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			languageHandler{}.ServeHTTP(w, r)
+			if w.Code != tc.wantCode {
+				t.Fatalf("want %d, got %d", tc.wantCode, w.Code)
+			}
+			if tc.wantCookie == "" {
+				return
+			}
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Name != languageCookie || cookies[0].Value != tc.wantCookie {
+				t.Fatalf("want %q language cookie, got %v", tc.wantCookie, cookies)
+			}
+			if next := w.Header().Get("Location"); next != tc.wantNext {
+				t.Fatalf("want redirect to %q, got %q", tc.wantNext, next)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package plopper
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/husio/plopper/i18n"
+	"github.com/husio/plopper/logging"
+)
+
+// Moderator decides whether a plop can be published. It is consulted before a
+// new plop is stored.
+type Moderator interface {
+	// Moderate returns the verdict for a plop that is about to be
+	// created. Plop ID is not yet assigned.
+	Moderate(context.Context, *Plop) (Verdict, error)
+}
+
+// ModerationNotifier is implemented by a Moderator that must be informed
+// after a plop was stored, for example to update its own state or to notify
+// moderators.
+type ModerationNotifier interface {
+	// PlopCreated is called after the plop was stored with the status
+	// assigned by the moderation.
+	PlopCreated(context.Context, *Plop)
+}
+
+// Verdict is the result of moderation.
+type Verdict struct {
+	Status PlopStatus
+	// Reason explains why the plop is not published. It is presented to
+	// the author of a rejected plop, so it must be translated using the
+	// locale of the context passed to the Moderator.
+	Reason string
+}
+
+// Published is the verdict of a plop that passed moderation.
+var Published = Verdict{Status: PlopPublished}
+
+// ModerationChain is a Moderator that consults all moderators in order and
+// returns the most severe verdict. Consultation stops at the first rejection.
+type ModerationChain []Moderator
+
+func (c ModerationChain) Moderate(ctx context.Context, p *Plop) (Verdict, error) {
+	verdict := Published
+	for _, m := range c {
+		v, err := m.Moderate(ctx, p)
+		if err != nil {
+			return verdict, fmt.Errorf("%T: %w", m, err)
+		}
+		if severity(v.Status) > severity(verdict.Status) {
+			verdict = v
+		}
+		if verdict.Status == PlopRejected {
+			break
+		}
+	}
+	return verdict, nil
+}
+
+func (c ModerationChain) PlopCreated(ctx context.Context, p *Plop) {
+	for _, m := range c {
+		if n, ok := m.(ModerationNotifier); ok {
+			n.PlopCreated(ctx, p)
+		}
+	}
+}
+
+func severity(s PlopStatus) int {
+	switch s {
+	case PlopPublished:
+		return 0
+	case PlopHeld:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// WordListFilter assigns given status to plops that contain any of the words.
+// Comparison is case insensitive and only whole words are matched.
+func WordListFilter(words []string, status PlopStatus) Moderator {
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	if len(quoted) == 0 {
+		return ModerationChain(nil)
+	}
+	return &wordListFilter{
+		rx:     regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`),
+		status: status,
+	}
+}
+
+type wordListFilter struct {
+	rx     *regexp.Regexp
+	status PlopStatus
+}
+
+func (f *wordListFilter) Moderate(ctx context.Context, p *Plop) (Verdict, error) {
+	if w := f.rx.FindString(p.Content); w != "" {
+		return Verdict{Status: f.status, Reason: i18n.FromContext(ctx).T("Content contains a forbidden word %q.", w)}, nil
+	}
+	return Published, nil
+}
+
+// LinkCountFilter assigns given status to plops containing more than max
+// links.
+func LinkCountFilter(max int, status PlopStatus) Moderator {
+	return &linkCountFilter{max: max, status: status}
+}
+
+type linkCountFilter struct {
+	max    int
+	status PlopStatus
+}
+
+var linkRx = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+`)
+
+func (f *linkCountFilter) Moderate(ctx context.Context, p *Plop) (Verdict, error) {
+	if n := len(linkRx.FindAllStringIndex(p.Content, -1)); n > f.max {
+		return Verdict{Status: f.status, Reason: i18n.FromContext(ctx).N(f.max, "Content contains %[2]d links, at most %[1]d is allowed.", "Content contains %[2]d links, at most %[1]d are allowed.", f.max, n)}, nil
+	}
+	return Published, nil
+}
+
+// DuplicateFilter rejects plops with the same content as a plop created by
+// the same author within the given time window.
+func DuplicateFilter(plops PlopStore, window time.Duration) Moderator {
+	return &duplicateFilter{plops: plops, window: window}
+}
+
+type duplicateFilter struct {
+	plops  PlopStore
+	window time.Duration
+}
+
+func (f *duplicateFilter) Moderate(ctx context.Context, p *Plop) (Verdict, error) {
+	recent, err := f.plops.ListPlops(ctx, PlopQuery{
+		AuthorID: p.AuthorID,
+		Content:  p.Content,
+		Statuses: []PlopStatus{PlopPublished, PlopHeld},
+		Limit:    1,
+	})
+	if err != nil {
+		return Published, fmt.Errorf("list plops: %w", err)
+	}
+	if len(recent) != 0 && time.Since(recent[0].CreatedAt) < f.window {
+		return Verdict{Status: PlopRejected, Reason: i18n.FromContext(ctx).T("You have already published this content.")}, nil
+	}
+	return Published, nil
+}
+
+// BlockedMentionFilter rejects plops that mention an account that blocked the
+// author. An account is mentioned by its ID prefixed with "@".
+func BlockedMentionFilter(plops PlopStore) Moderator {
+	return &blockedMentionFilter{plops: plops}
+}
+
+type blockedMentionFilter struct {
+	plops PlopStore
+}
+
+var mentionRx = regexp.MustCompile(`(?:^|\s)@([A-Za-z0-9_-]+)`)
+
+func (f *blockedMentionFilter) Moderate(ctx context.Context, p *Plop) (Verdict, error) {
+	for _, m := range mentionRx.FindAllStringSubmatch(p.Content, -1) {
+		blocked, err := f.plops.HasRelation(ctx, m[1], p.AuthorID, RelationBlock)
+		if err != nil {
+			return Published, fmt.Errorf("has relation: %w", err)
+		}
+		if blocked {
+			return Verdict{Status: PlopRejected, Reason: i18n.FromContext(ctx).T("You cannot mention %s.", m[1])}, nil
+		}
+	}
+	return Published, nil
+}
+
+// LogModerator is a Moderator that publishes everything and writes a log entry
+// for each plop that was not published.
+type LogModerator struct{}
+
+func (LogModerator) Moderate(context.Context, *Plop) (Verdict, error) {
+	return Published, nil
+}
+
+func (LogModerator) PlopCreated(ctx context.Context, p *Plop) {
+	if p.Status != PlopPublished {
+		logging.Infof(ctx, "plop %s of %s is %s", p.ID, p.AuthorID, p.Status)
+	}
+}
@@ -0,0 +1,84 @@
+package plopper
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestModerationChain(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+
+	if _, err := store.Create(ctx, "author", "already published", PlopPublished); err != nil {
+		t.Fatalf("cannot create plop: %s", err)
+	}
+
+	moderator := ModerationChain{
+		DuplicateFilter(store, time.Hour),
+		LinkCountFilter(1, PlopHeld),
+		WordListFilter([]string{"spam", " ", "ham"}, PlopHeld),
+	}
+
+	cases := map[string]struct {
+		author  string
+		content string
+		want    PlopStatus
+	}{
+		"clean": {
+			author:  "author",
+			content: "hello world",
+			want:    PlopPublished,
+		},
+		"duplicate": {
+			author:  "author",
+			content: "already published",
+			want:    PlopRejected,
+		},
+		"duplicate of another author": {
+			author:  "another",
+			content: "already published",
+			want:    PlopPublished,
+		},
+		"single link": {
+			author:  "author",
+			content: "see https://example.com",
+			want:    PlopPublished,
+		},
+		"too many links": {
+			author:  "author",
+			content: "see https://example.com and www.example.com",
+			want:    PlopHeld,
+		},
+		"forbidden word": {
+			author:  "author",
+			content: "This is SPAM!",
+			want:    PlopHeld,
+		},
+		"forbidden word within another word": {
+			author:  "author",
+			content: "hamster",
+			want:    PlopPublished,
+		},
+	}
+
+	for testName, tc := range cases {
+		t.Run(testName, func(t *testing.T) {
+			v, err := moderator.Moderate(ctx, &Plop{AuthorID: tc.author, Content: tc.content})
+			if err != nil {
+				t.Fatalf("moderate: %s", err)
+			}
+			if v.Status != tc.want {
+				t.Fatalf("want %q status, got %q (%s)", tc.want, v.Status, v.Reason)
+			}
+			if v.Status != PlopPublished && v.Reason == "" {
+				t.Fatal("reason not provided")
+			}
+		})
+	}
+}
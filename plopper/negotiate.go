@@ -0,0 +1,124 @@
+package plopper
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/husio/plopper/i18n"
+	"github.com/husio/plopper/logging"
+)
+
+// wantsJSON returns true if the client prefers a JSON response over HTML, as
+// described by the Accept header. HTML is preferred when both are equally
+// acceptable, so that browsers always get a page. The response is marked as
+// depending on the Accept header.
+func wantsJSON(w http.ResponseWriter, r *http.Request) bool {
+	addVary(w.Header(), "Accept")
+
+	var htmlQ, jsonQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case mediaType == "*/*":
+			htmlQ, jsonQ = maxQ(htmlQ, q), maxQ(jsonQ, q)
+		case mediaType == "text/html", mediaType == "text/*", mediaType == "application/xhtml+xml":
+			htmlQ = maxQ(htmlQ, q)
+		case mediaType == "application/json", strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"):
+			jsonQ = maxQ(jsonQ, q)
+		}
+	}
+	return jsonQ > htmlQ
+}
+
+// addVary adds given request header to the Vary header, unless it is already
+// listed.
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, listed := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(listed), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+func maxQ(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// writeJSON writes content serialized to JSON.
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, content interface{}) {
+	writeJSONAs(w, r, code, "application/json", content)
+}
+
+// writeJSONAs writes content serialized to JSON, using given content type.
+func writeJSONAs(w http.ResponseWriter, r *http.Request, code int, contentType string, content interface{}) {
+	b, err := json.MarshalIndent(content, "", "\t")
+	if err != nil {
+		logging.Errorf(r.Context(), "cannot serialize JSON response: %s", err)
+		const code = http.StatusInternalServerError
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	w.Header().Set("content-type", contentType)
+	w.WriteHeader(code)
+	_, _ = w.Write(b)
+}
+
+// problem describes why the request failed, as defined by RFC 7807. It is the
+// JSON representation of a failure page.
+type problem struct {
+	// Type is a URI identifying the problem type. Only "about:blank" is
+	// used, which means that the status code is all there is to know.
+	Type string `json:"type"`
+	// Title is the translated status text.
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail is the translated description of this occurrence of the
+	// problem.
+	Detail string `json:"detail,omitempty"`
+	// Instance is the URI of the request that failed.
+	Instance string `json:"instance,omitempty"`
+	// RequestID allows to find the request in logs. It is an extension
+	// member.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeProblem writes a problem document describing the failure of the
+// request. Description is translated and formatted with given arguments. It
+// can be empty.
+func writeProblem(w http.ResponseWriter, r *http.Request, code int, description string, args ...interface{}) {
+	p := newProblem(r, code)
+	if description != "" {
+		p.Detail = i18n.FromContext(r.Context()).T(description, args...)
+	}
+	writeJSONAs(w, r, code, "application/problem+json", p)
+}
+
+// newProblem returns a problem describing the failure of the request with
+// given status code, without any detail.
+func newProblem(r *http.Request, code int) problem {
+	return problem{
+		Type:      "about:blank",
+		Title:     i18n.FromContext(r.Context()).T(http.StatusText(code)),
+		Status:    code,
+		Instance:  r.RequestURI,
+		RequestID: logging.RequestID(r.Context()),
+	}
+}
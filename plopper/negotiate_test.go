@@ -0,0 +1,171 @@
+package plopper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/husio/plopper/i18n"
+)
+
+func TestWantsJSON(t *testing.T) {
+	cases := map[string]bool{
+		"":                            false,
+		"*/*":                         false,
+		"text/html":                   false,
+		"application/json":            true,
+		"application/problem+json":    true,
+		"application/json, */*;q=0.8": true,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": false,
+		"text/html;q=0.5, application/json":                               true,
+		"application/json;q=0.5, text/*":                                  false,
+		"application/json;q=0, */*":                                       false,
+		"application/json;q=invalid":                                      false,
+		"text/plain":                                                      false,
+	}
+	for accept, want := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		if got := wantsJSON(w, r); got != want {
+			t.Errorf("%q: want %v, got %v", accept, want, got)
+		}
+		if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept" {
+			t.Errorf("%q: want the response to vary on Accept, got %q", accept, vary)
+		}
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+
+	id := PlopID(bytes.Repeat([]byte{0xab}, 16))
+	missing := strings.Repeat("ff", 16)
+	createdAt := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	if _, err := store.InsertPlops(ctx, []*Plop{
+		{ID: id, AuthorID: "alice", CreatedAt: createdAt, Content: "hello", Status: PlopPublished},
+	}); err != nil {
+		t.Fatalf("cannot insert plop: %s", err)
+	}
+
+	conf := DefaultSettings()
+	profiles := newProfileResolver(&fakeDirectory{}, store)
+	mux := http.NewServeMux()
+	mux.Handle("/", &listPlopsHandler{plops: store, profiles: profiles, conf: &conf})
+	mux.Handle("/plop/", http.StripPrefix("/plop/", &showPlopHandler{plops: store, profiles: profiles, conf: &conf}))
+	handler := LanguageMiddleware()(mux)
+
+	serve := func(path, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", accept)
+		r.Header.Set("Accept-Language", "pl")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("page", func(t *testing.T) {
+		w := serve("/", "application/json")
+		if w.Code != http.StatusOK {
+			t.Fatalf("want 200, got %d", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("want JSON, got %q", ct)
+		}
+		var got struct {
+			Plops []struct {
+				ID        string    `json:"id"`
+				AuthorID  string    `json:"author_id"`
+				CreatedAt time.Time `json:"created_at"`
+				EditedAt  *string   `json:"edited_at"`
+				Content   string    `json:"content"`
+				Status    string    `json:"status"`
+			} `json:"plops"`
+			Timeline string `json:"timeline"`
+			IsNewest bool   `json:"is_newest"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("cannot decode: %s\n%s", err, w.Body)
+		}
+		if len(got.Plops) != 1 || got.Plops[0].ID != id.String() || got.Plops[0].Content != "hello" || !got.Plops[0].CreatedAt.Equal(createdAt) {
+			t.Fatalf("unexpected plops: %s", w.Body)
+		}
+		if got.Plops[0].EditedAt != nil {
+			t.Fatalf("edit time of not edited plop must be omitted: %s", w.Body)
+		}
+		if got.Timeline != timelineGlobal || !got.IsNewest {
+			t.Fatalf("unexpected page state: %s", w.Body)
+		}
+	})
+
+	t.Run("browser", func(t *testing.T) {
+		w := serve("/plop/"+id.String(), "text/html,application/xhtml+xml,*/*;q=0.8")
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Fatalf("want HTML, got %q", ct)
+		}
+		if !strings.Contains(w.Body.String(), `id="plop-`+id.String()+`"`) {
+			t.Fatalf("plop not rendered:\n%s", w.Body)
+		}
+	})
+
+	t.Run("problem", func(t *testing.T) {
+		w := serve("/plop/"+missing, "application/json")
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("want 404, got %d", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Fatalf("want a problem document, got %q", ct)
+		}
+		var got problem
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("cannot decode: %s\n%s", err, w.Body)
+		}
+		want := problem{Type: "about:blank", Title: "Nie znaleziono", Status: http.StatusNotFound, Instance: "/plop/" + missing}
+		if got != want {
+			t.Fatalf("want %+v, got %+v", want, got)
+		}
+	})
+}
+
+func TestRenderFailJSON(t *testing.T) {
+	serve := func(code int) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/create", nil)
+		r.Header.Set("Accept", "application/json")
+		r = r.WithContext(i18n.NewContext(r.Context(), i18n.English))
+		w := httptest.NewRecorder()
+		renderFail(w, r, code, "Content must be at least %d characters.", 3)
+		return w
+	}
+
+	w := serve(http.StatusBadRequest)
+	if ct := w.Header().Get("Content-Type"); w.Code != http.StatusBadRequest || ct != "application/problem+json" {
+		t.Fatalf("want 400 problem document, got %d %q", w.Code, ct)
+	}
+	var p problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "Bad Request" || p.Detail != "Content must be at least 3 characters." || p.Status != http.StatusBadRequest {
+		t.Fatalf("unexpected problem: %+v", p)
+	}
+
+	// Not every use describes an error.
+	w = serve(http.StatusAccepted)
+	if ct := w.Header().Get("Content-Type"); w.Code != http.StatusAccepted || ct != "application/json" {
+		t.Fatalf("want 202 JSON document, got %d %q", w.Code, ct)
+	}
+	if want := `"description": "Content must be at least 3 characters."`; !strings.Contains(w.Body.String(), want) {
+		t.Fatalf("want %s in %s", want, w.Body)
+	}
+}
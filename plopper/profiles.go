@@ -0,0 +1,232 @@
+package plopper
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/husio/plopper/lith"
+	"github.com/husio/plopper/logging"
+	"github.com/husio/plopper/metrics"
+)
+
+var profileLookups = metrics.NewCounter(
+	"plopper_profile_lookups_total",
+	"Number of account profile lookups by the source that resolved them.",
+	"source")
+
+// AccountDirectory provides public details of accounts. It is implemented by
+// lith.Client.
+type AccountDirectory interface {
+	Accounts(ctx context.Context, accountIDs []string) ([]*lith.Account, error)
+}
+
+const (
+	// profileCacheTTL is how long a resolved profile is served from the
+	// cache before it is fetched again.
+	profileCacheTTL = 5 * time.Minute
+	// profileFallbackTTL is how long a profile resolved while any of the
+	// sources is failing is cached. It is short, so that the complete
+	// profile is fetched soon after the source recovers, but it prevents a
+	// lookup on every page while the source is down.
+	profileFallbackTTL = 30 * time.Second
+	// profileLookupTimeout limits the time the directory is given to
+	// resolve accounts, so that pages are not held by a slow directory.
+	profileLookupTimeout = 2 * time.Second
+	// profileCacheSize is the maximum number of cached profiles.
+	profileCacheSize = 10000
+	// accountsPerLookup is the maximum number of accounts requested from
+	// the directory in a single call.
+	accountsPerLookup = 100
+)
+
+// profileResolver maps account IDs to profiles. Accounts are looked up both in
+// the directory and in the local store. Display name and avatar set locally
+// take precedence over those provided by the directory. Local profiles alone
+// are used for accounts unknown to the directory, or when the directory cannot
+// be reached. Resolved profiles are cached.
+type profileResolver struct {
+	directory AccountDirectory
+	store     PlopStore
+	now       func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedProfile
+}
+
+type cachedProfile struct {
+	profile   *Profile
+	expiresAt time.Time
+}
+
+func newProfileResolver(directory AccountDirectory, store PlopStore) *profileResolver {
+	return &profileResolver{
+		directory: directory,
+		store:     store,
+		now:       time.Now,
+		cache:     make(map[string]cachedProfile),
+	}
+}
+
+// Resolve returns profiles of all given accounts, by account ID. An account
+// that cannot be resolved is represented by a profile with only the account
+// ID set, so that the result always contains all accounts.
+func (r *profileResolver) Resolve(ctx context.Context, accountIDs []string) map[string]*Profile {
+	profiles := make(map[string]*Profile, len(accountIDs))
+	missing := r.fromCache(accountIDs, profiles)
+	if len(missing) == 0 {
+		return profiles
+	}
+
+	ttl := profileCacheTTL
+	local, err := r.store.Profiles(ctx, missing)
+	if err != nil {
+		logging.Errorf(ctx, "cannot fetch local profiles: %s", err)
+		ttl = profileFallbackTTL
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, profileLookupTimeout)
+	defer cancel()
+	for start := 0; start < len(missing); start += accountsPerLookup {
+		chunk := missing[start:]
+		if len(chunk) > accountsPerLookup {
+			chunk = chunk[:accountsPerLookup]
+		}
+		accounts, err := r.directory.Accounts(lookupCtx, chunk)
+		if err != nil {
+			logging.Errorf(ctx, "cannot fetch accounts from the directory: %s", err)
+			ttl = profileFallbackTTL
+			break
+		}
+		for _, a := range accounts {
+			p := &Profile{AccountID: a.AccountID}
+			if l, ok := local[a.AccountID]; ok {
+				merged := *l
+				p = &merged
+			}
+			if p.DisplayName == "" {
+				p.DisplayName = strings.TrimSpace(a.DisplayName)
+			}
+			if p.AvatarURL == "" {
+				p.AvatarURL = avatarURL(a.AvatarURL)
+			}
+			profiles[a.AccountID] = p
+			profileLookups.Inc("directory")
+		}
+	}
+	for _, id := range unresolved(missing, profiles) {
+		if p, ok := local[id]; ok {
+			profiles[id] = p
+			profileLookups.Inc("store")
+		}
+	}
+
+	for _, id := range unresolved(missing, profiles) {
+		profiles[id] = &Profile{AccountID: id}
+		profileLookups.Inc("none")
+	}
+
+	// A partial result is cached only briefly, so that the accounts are
+	// looked up again soon after the failing source recovers.
+	r.toCache(profiles, ttl)
+	return profiles
+}
+
+// fromCache copies all cached profiles of given accounts to profiles and
+// returns IDs of accounts that are not cached. Returned IDs are unique.
+func (r *profileResolver) fromCache(accountIDs []string, profiles map[string]*Profile) []string {
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var missing []string
+	seen := make(map[string]bool, len(accountIDs))
+	for _, id := range accountIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if c, ok := r.cache[id]; ok && now.Before(c.expiresAt) {
+			profiles[id] = c.profile
+			profileLookups.Inc("cache")
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+func (r *profileResolver) toCache(profiles map[string]*Profile, ttl time.Duration) {
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.cache)+len(profiles) > profileCacheSize {
+		for id, c := range r.cache {
+			if !now.Before(c.expiresAt) {
+				delete(r.cache, id)
+			}
+		}
+		if len(r.cache)+len(profiles) > profileCacheSize {
+			r.cache = make(map[string]cachedProfile)
+		}
+	}
+	for id, p := range profiles {
+		r.cache[id] = cachedProfile{profile: p, expiresAt: now.Add(ttl)}
+	}
+}
+
+// Forget removes the profile of given account from the cache, so that changes
+// are visible immediately.
+func (r *profileResolver) Forget(accountID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cache, accountID)
+}
+
+func unresolved(accountIDs []string, profiles map[string]*Profile) []string {
+	var missing []string
+	for _, id := range accountIDs {
+		if _, ok := profiles[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// avatarURL returns given address if it can be used as an image source.
+// Content-Security-Policy allows only images served over HTTPS.
+func avatarURL(raw string) string {
+	if strings.HasPrefix(raw, "https://") {
+		return raw
+	}
+	return ""
+}
+
+// withProfiles resolves profiles of given accounts and returns a request that
+// carries them, so that they are available to the profile template function.
+func (r *profileResolver) withProfiles(req *http.Request, accountIDs []string) *http.Request {
+	profiles := r.Resolve(req.Context(), accountIDs)
+	return req.WithContext(context.WithValue(req.Context(), profilesContextKey, profiles))
+}
+
+// requestProfile returns the profile of given account attached to the request
+// context by withProfiles. An account without a resolved profile is
+// represented by its ID.
+func requestProfile(ctx context.Context, accountID string) *Profile {
+	profiles, _ := ctx.Value(profilesContextKey).(map[string]*Profile)
+	if p, ok := profiles[accountID]; ok {
+		return p
+	}
+	return &Profile{AccountID: accountID}
+}
+
+// plopAuthors returns IDs of authors of given plops.
+func plopAuthors(plops []*Plop) []string {
+	ids := make([]string, len(plops))
+	for i, p := range plops {
+		ids[i] = p.AuthorID
+	}
+	return ids
+}
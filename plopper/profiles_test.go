@@ -0,0 +1,118 @@
+package plopper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/husio/plopper/lith"
+)
+
+type fakeDirectory struct {
+	accounts map[string]*lith.Account
+	err      error
+	calls    [][]string
+	// deadline is the deadline of the last call context.
+	deadline time.Time
+}
+
+func (d *fakeDirectory) Accounts(ctx context.Context, accountIDs []string) ([]*lith.Account, error) {
+	d.calls = append(d.calls, accountIDs)
+	d.deadline, _ = ctx.Deadline()
+	if d.err != nil {
+		return nil, d.err
+	}
+	var found []*lith.Account
+	for _, id := range accountIDs {
+		if a, ok := d.accounts[id]; ok {
+			found = append(found, a)
+		}
+	}
+	return found, nil
+}
+
+func TestProfileResolver(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+	if err := store.SaveProfile(ctx, &Profile{AccountID: "bob", DisplayName: "Bob (local)"}); err != nil {
+		t.Fatalf("cannot save profile: %s", err)
+	}
+
+	directory := &fakeDirectory{accounts: map[string]*lith.Account{
+		"alice": {AccountID: "alice", DisplayName: "Alice", AvatarURL: "https://example.com/alice.png"},
+		"eve":   {AccountID: "eve", DisplayName: "Eve", AvatarURL: "javascript:alert(1)"},
+	}}
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	resolver := newProfileResolver(directory, store)
+	resolver.now = func() time.Time { return now }
+
+	names := func(profiles map[string]*Profile) map[string]string {
+		res := make(map[string]string)
+		for id, p := range profiles {
+			res[id] = p.Name()
+		}
+		return res
+	}
+
+	profiles := resolver.Resolve(ctx, []string{"alice", "bob", "alice", "eve", "mallory"})
+	want := map[string]string{"alice": "Alice", "bob": "Bob (local)", "eve": "Eve", "mallory": "mallory"}
+	if got := names(profiles); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if got := profiles["alice"].AvatarURL; got != "https://example.com/alice.png" {
+		t.Errorf("unexpected alice avatar: %q", got)
+	}
+	if got := profiles["eve"].AvatarURL; got != "" {
+		t.Errorf("want unsafe avatar dropped, got %q", got)
+	}
+	if len(directory.calls) != 1 {
+		t.Fatalf("want a single directory call, got %q", directory.calls)
+	}
+	if directory.deadline.IsZero() {
+		t.Fatal("directory lookup must not wait without a limit")
+	}
+	requested := append([]string(nil), directory.calls[0]...)
+	sort.Strings(requested)
+	if want := []string{"alice", "bob", "eve", "mallory"}; !reflect.DeepEqual(requested, want) {
+		t.Fatalf("want each account requested once, got %q", requested)
+	}
+
+	// Cached profiles are served without asking the directory.
+	if got := names(resolver.Resolve(ctx, []string{"alice", "mallory"})); got["alice"] != "Alice" || got["mallory"] != "mallory" {
+		t.Fatalf("unexpected cached profiles: %v", got)
+	}
+	if len(directory.calls) != 1 {
+		t.Fatalf("want cached profiles used, got %d directory calls", len(directory.calls))
+	}
+
+	// Once the cache expires, local profiles are used while the directory
+	// is failing. Such result is cached only briefly.
+	now = now.Add(profileCacheTTL)
+	directory.err = errors.New("unavailable")
+	want = map[string]string{"alice": "alice", "bob": "Bob (local)"}
+	if got := names(resolver.Resolve(ctx, []string{"alice", "bob"})); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if got := names(resolver.Resolve(ctx, []string{"alice", "bob"})); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if len(directory.calls) != 2 {
+		t.Fatalf("want fallback profiles cached, got %d directory calls", len(directory.calls))
+	}
+	directory.err = nil
+	now = now.Add(profileFallbackTTL)
+	if got := names(resolver.Resolve(ctx, []string{"alice"})); got["alice"] != "Alice" {
+		t.Fatalf("want directory profile after recovery, got %v", got)
+	}
+	if len(directory.calls) != 3 {
+		t.Fatalf("want 3 directory calls, got %d", len(directory.calls))
+	}
+}
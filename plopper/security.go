@@ -0,0 +1,70 @@
+package plopper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+)
+
+// SecurityHeadersMiddleware returns an http.Handler middleware that sets
+// security related headers on every response.
+//
+// A new nonce is generated for each request and allowed by the
+// Content-Security-Policy. Within decorated http.Handler, CSPNonce function
+// can be called to retrieve it.
+func SecurityHeadersMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &securityHeadersMiddleware{next: next}
+	}
+}
+
+type securityHeadersMiddleware struct {
+	next http.Handler
+}
+
+func (m *securityHeadersMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	nonce := newCSPNonce()
+
+	h := w.Header()
+	h.Set("Content-Security-Policy", ""+
+		"default-src 'self'; "+
+		"script-src 'self' 'nonce-"+nonce+"'; "+
+		"style-src 'self' 'nonce-"+nonce+"'; "+
+		"img-src 'self' data: https:; "+
+		"object-src 'none'; "+
+		"base-uri 'self'; "+
+		"form-action 'self'; "+
+		"frame-ancestors 'none'")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+	if isHTTPS(r) {
+		h.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+	}
+
+	ctx := context.WithValue(r.Context(), cspNonceContextKey, nonce)
+	m.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// isHTTPS returns true if the client is using a secure connection. Heroku
+// terminates TLS at the router and informs about it using X-Forwarded-Proto.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// CSPNonce returns the nonce allowed by the Content-Security-Policy of the
+// current response. An empty string is returned if the context was not
+// created by SecurityHeadersMiddleware.
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceContextKey).(string)
+	return nonce
+}
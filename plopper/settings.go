@@ -0,0 +1,147 @@
+package plopper
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/husio/plopper/lith"
+)
+
+// Settings configure the HTTP application.
+type Settings struct {
+	// AuthUI is the address of the lith user interface. Login and account
+	// management pages are proxied to it.
+	AuthUI string
+	// CSRFSecret is used to sign CSRF tokens.
+	CSRFSecret []byte
+
+	// PlopsPerPage is the number of plops displayed on a single page.
+	PlopsPerPage int
+	// MinContentLength and MaxContentLength limit the length of plop
+	// content, in user perceived characters.
+	MinContentLength int
+	MaxContentLength int
+	// MaxNewlines is the number of consecutive line breaks kept in plop
+	// content.
+	MaxNewlines int
+
+	// CreatePermission is required in order to create plops.
+	CreatePermission string
+	// ModeratePermission is required in order to see and moderate plops
+	// that are not published.
+	ModeratePermission string
+
+	// CreateRateLimit is the maximum number of plops a single account can
+	// create within CreateRateWindow. Zero disables the limit.
+	CreateRateLimit  int
+	CreateRateWindow time.Duration
+
+	// TemplateDir is the directory templates are loaded from whenever they
+	// change, instead of using the embedded ones. Meant for development.
+	TemplateDir string
+}
+
+// DefaultSettings returns settings with all values except AuthUI and
+// CSRFSecret set to sensible defaults.
+func DefaultSettings() Settings {
+	return Settings{
+		PlopsPerPage:       50,
+		MinContentLength:   3,
+		MaxContentLength:   1024,
+		MaxNewlines:        2,
+		CreatePermission:   "plop:create",
+		ModeratePermission: "plop:moderate",
+		CreateRateLimit:    10,
+		CreateRateWindow:   time.Minute,
+	}
+}
+
+// Validate returns an error if settings cannot be used.
+func (s *Settings) Validate() error {
+	if u, err := url.Parse(s.AuthUI); err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New("auth UI must be an absolute URL")
+	}
+	if len(s.CSRFSecret) == 0 {
+		return errors.New("CSRF secret is required")
+	}
+	if s.PlopsPerPage < 1 {
+		return errors.New("plops per page must be positive")
+	}
+	if s.MinContentLength < 1 || s.MaxContentLength < s.MinContentLength {
+		return errors.New("content length limits must be positive, with max not smaller than min")
+	}
+	if s.MaxNewlines < 1 {
+		return errors.New("max newlines must be positive")
+	}
+	if s.CreatePermission == "" || s.ModeratePermission == "" {
+		return errors.New("permission names are required")
+	}
+	if s.CreateRateLimit < 0 || (s.CreateRateLimit > 0 && s.CreateRateWindow <= 0) {
+		return errors.New("create rate limit requires a positive window")
+	}
+	return nil
+}
+
+// isModerator returns true if the current account is allowed to see and
+// moderate plops that are not published.
+func (s *Settings) isModerator(ctx context.Context) bool {
+	account, ok := lith.CurrentAccount(ctx)
+	return ok && contains(account.Permissions, s.ModeratePermission)
+}
+
+// rateLimiter allows up to limit events per key within a fixed window.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// Allow registers an event and returns false if the limit for given key is
+// exceeded. A limiter with no limit allows all events.
+func (l *rateLimiter) Allow(key string, now time.Time) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		if !ok && len(l.windows) > 10000 {
+			l.dropExpired(now)
+		}
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+func (l *rateLimiter) dropExpired(now time.Time) {
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, key)
+		}
+	}
+}
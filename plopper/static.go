@@ -0,0 +1,77 @@
+package plopper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	//go:embed static
+	staticRaw embed.FS
+	statics   = mustLoadStatics(staticRaw, "static")
+)
+
+// staticAssets serves embedded files under content addressed names. Because
+// the name of a file changes together with its content, each file can be
+// cached by the client forever.
+type staticAssets struct {
+	// byName maps the original file name to the versioned one.
+	byName map[string]string
+	// byVersion maps the versioned file name to its content.
+	byVersion map[string][]byte
+}
+
+func mustLoadStatics(fsys fs.FS, dir string) *staticAssets {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	s := &staticAssets{
+		byName:    make(map[string]string),
+		byVersion: make(map[string][]byte),
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		sum := sha256.Sum256(b)
+		ext := path.Ext(e.Name())
+		versioned := strings.TrimSuffix(e.Name(), ext) + "." + hex.EncodeToString(sum[:6]) + ext
+		s.byName[e.Name()] = versioned
+		s.byVersion[versioned] = b
+	}
+	return s
+}
+
+// URL returns the versioned URL of the static file with the given name.
+func (s *staticAssets) URL(name string) (string, error) {
+	versioned, ok := s.byName[name]
+	if !ok {
+		return "", fmt.Errorf("static file %q does not exist", name)
+	}
+	return "/static/" + versioned, nil
+}
+
+// ServeHTTP serves a static file. Request path must be stripped of the
+// "/static/" prefix.
+func (s *staticAssets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, ok := s.byVersion[r.URL.Path]
+	if !ok {
+		renderStd(w, r, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("cache-control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(b))
+}
@@ -0,0 +1,203 @@
+// Report the time zone of the browser, so that the server can present times
+// in it.
+(function() {
+	var tz = Intl.DateTimeFormat().resolvedOptions().timeZone
+	if (tz && document.cookie.indexOf("tz=" + encodeURIComponent(tz)) === -1) {
+		document.cookie = "tz=" + encodeURIComponent(tz) + "; path=/; max-age=31536000; samesite=lax"
+	}
+})()
+
+// contentRules normalizes and measures plop content the same way the server
+// does it. The limits and character classes are provided by the server in data
+// attributes of the content field.
+function contentRules(data) {
+	// Character classes are space separated hexadecimal code points and
+	// ranges of them.
+	var charClass = function(ranges) {
+		return ranges.split(" ").map(function(r) {
+			return r.split("-").map(function(c) { return "\\u{" + c + "}" }).join("-")
+		}).join("")
+	}
+	var space = charClass(data.space),
+	    isSpace = new RegExp("^[" + space + "]$", "u"),
+	    isZeroWidth = new RegExp("^[" + charClass(data.zeroWidth) + "]$", "u"),
+	    zeroWidthRuns = new RegExp("[" + charClass(data.zeroWidth) + "]+", "gu"),
+	    joiner = new RegExp("[" + charClass(data.joiner) + "]", "u"),
+	    blank = new RegExp("^[" + space + "]*$", "u"),
+	    surrounding = new RegExp("^[" + space + "]+|[" + space + "]+$", "gu"),
+	    forbidden = new RegExp("[" + charClass(data.control) + charClass(data.bidiControl) + "]", "u"),
+	    extend = new RegExp("^[\\p{M}" + charClass(data.extend) + "]$", "u"),
+	    regionalIndicator = new RegExp("^[" + charClass(data.regionalIndicator) + "]$", "u"),
+	    newlines = parseInt(data.maxNewlines, 10)
+
+	var isVisible = function(c) {
+		return c !== "" && !isSpace.test(c) && !isZeroWidth.test(c)
+	}
+	return {
+		min: parseInt(data.minLength, 10),
+		max: parseInt(data.maxLength, 10),
+		normalize: function(s) {
+			s = s.replace(/\r\n?|[\u2028\u2029]/g, "\n")
+			// Keep a single joiner between two visible characters only.
+			// Checking code units around a run is enough, because
+			// spaces and zero width characters are never surrogates.
+			s = s.replace(zeroWidthRuns, function(run, offset, all) {
+				var j = run.match(joiner)
+				return j && isVisible(all.charAt(offset - 1)) && isVisible(all.charAt(offset + run.length)) ? j[0] : ""
+			})
+			var out = "", blanks = 0
+			s.split("\n").forEach(function(line) {
+				if (blank.test(line)) {
+					blanks++
+					return
+				}
+				if (out !== "") {
+					out += "\n".repeat(Math.min(blanks + 1, newlines))
+				}
+				blanks = 0
+				out += line
+			})
+			// Normalize last, like the server, because removed
+			// characters might have separated combining marks from
+			// their base characters.
+			return out.replace(surrounding, "").normalize("NFC")
+		},
+		// length counts user perceived characters like graphemeCount.
+		length: function(s) {
+			var n = 0, prev = "", flag = false
+			Array.from(s).forEach(function(c) {
+				if (n > 0 && (prev === "\u200d" || extend.test(c))) {
+					// Continues the current character.
+				} else if (n > 0 && flag && regionalIndicator.test(c)) {
+					flag = false
+				} else {
+					n++
+					flag = regionalIndicator.test(c)
+				}
+				prev = c
+			})
+			return n
+		},
+		forbidden: function(s) {
+			return forbidden.test(s)
+		}
+	}
+}
+
+// measure presents the length of the field content, normalized and measured
+// the way the server does it, in the element referenced by the data-counter
+// attribute. If the field has a data-invalid message, it cannot be submitted
+// while its content is not valid. The returned function updates the counter.
+function measure(field) {
+	var rules = contentRules(field.dataset),
+	    counter = document.getElementById(field.dataset.counter)
+	var update = function() {
+		var text = rules.normalize(field.value),
+		    len = rules.length(text),
+		    valid = len >= rules.min && len <= rules.max && !rules.forbidden(text)
+		if (field.dataset.invalid) {
+			field.setCustomValidity(valid || field.value === "" ? "" : field.dataset.invalid)
+		}
+		if (counter) {
+			counter.classList.toggle("invalid", !valid)
+			counter.textContent = field.value === "" ? "" : " " + len + "/" + rules.max
+		}
+		// Adjust height
+		field.style.overflowY = "hidden"
+		field.style.height = "1px"
+		field.style.height = (field.scrollHeight)+"px"
+	}
+	update()
+	field.addEventListener("input", update)
+	field.addEventListener("change", update)
+	field.addEventListener("keyup", update)
+	return update
+}
+
+document.addEventListener("DOMContentLoaded", function() {
+	var reason = document.getElementById("reason")
+	if (reason) {
+		measure(reason)
+	}
+})
+
+document.addEventListener("DOMContentLoaded", function() {
+	var content = document.getElementById("content"),
+	    info = document.getElementById("info")
+	if (!content || !info) {
+		return
+	}
+	var update = measure(content)
+	content.addEventListener("keydown", function(e) {
+		if (e.ctrlKey && e.keyCode === 13) {
+			if (content.form.requestSubmit) {
+				content.form.requestSubmit()
+			} else {
+				content.form.submit()
+			}
+		}
+	})
+
+	// Publish without reloading the page. The server responds with JSON
+	// when asked to, otherwise the regular POST-redirect-GET flow is used.
+	var form = content.form,
+	    error = document.getElementById("create-error"),
+	    plops = document.getElementById("plops")
+	if (!window.fetch || !error || !plops) {
+		return
+	}
+	var showError = function(msg) {
+		error.textContent = msg
+		if (msg) {
+			content.setAttribute("aria-invalid", "true")
+		} else {
+			content.removeAttribute("aria-invalid")
+		}
+	}
+	form.addEventListener("submit", function(e) {
+		e.preventDefault()
+		var button = form.querySelector("button")
+		if (button) {
+			button.disabled = true
+		}
+		fetch(form.action, {
+			method: "POST",
+			body: new URLSearchParams(new FormData(form)),
+			headers: {"Accept": "application/json"},
+			credentials: "same-origin"
+		}).then(function(resp) {
+			return resp.json().then(function(body) {
+				if (resp.status === 201) {
+					var empty = plops.querySelector(".no-plops")
+					if (empty) {
+						empty.remove()
+					}
+					plops.insertAdjacentHTML("afterbegin", body.html)
+					content.value = ""
+					showError("")
+				} else if (resp.status === 202) {
+					// Held for moderation, so there is nothing to show yet.
+					content.value = ""
+					showError("")
+					info.textContent = " " + body.description
+					return
+				} else {
+					showError(body.detail || body.title)
+				}
+				update()
+			}).catch(function() {
+				// The server has responded, so the plop might have been
+				// published already and must not be submitted again.
+				showError(form.dataset.failure)
+			})
+		}, function() {
+			// No response was received, so the plop was not published.
+			// Let the browser submit the form instead.
+			form.submit()
+		}).finally(function() {
+			if (button) {
+				button.disabled = false
+			}
+		})
+	})
+})
@@ -5,63 +5,321 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type PlopStore interface {
-	Create(context.Context, string, string) (PlopID, error)
-	ListPlops(context.Context, time.Time, int) ([]*Plop, error)
+	Create(context.Context, string, string, PlopStatus) (PlopID, error)
+	ListPlops(context.Context, PlopQuery) ([]*Plop, error)
 	Plop(context.Context, PlopID) (*Plop, error)
+	// PlopsByID returns plops with given IDs, in the order of IDs. IDs
+	// that do not exist are skipped.
+	PlopsByID(context.Context, []PlopID) ([]*Plop, error)
+	SetPlopStatus(context.Context, PlopID, PlopStatus) error
+	// InsertPlops inserts given plops as they are, skipping those with an
+	// ID that is already present. It returns the number of inserted plops.
+	InsertPlops(context.Context, []*Plop) (int, error)
+
+	CreateReport(context.Context, PlopID, string, string) error
+	ListOpenReports(context.Context, int) ([]*Report, error)
+	ApplyModerationAction(context.Context, *ModerationAction) error
+	ListModerationActions(context.Context, int) ([]*ModerationAction, error)
+
+	CreateRelation(context.Context, string, string, RelationKind) error
+	DeleteRelation(context.Context, string, string, RelationKind) error
+	ListRelations(context.Context, string) ([]*Relation, error)
+	HasRelation(context.Context, string, string, RelationKind) (bool, error)
+
+	Follow(context.Context, string, string) error
+	Unfollow(context.Context, string, string) error
+	IsFollowing(context.Context, string, string) (bool, error)
+	ListFollowers(context.Context, string, int) ([]string, error)
+	ListFollowing(context.Context, string, int) ([]string, error)
+
+	// Profiles returns locally stored profiles of given accounts, by
+	// account ID. Accounts without a profile are skipped.
+	Profiles(context.Context, []string) (map[string]*Profile, error)
+	SaveProfile(context.Context, *Profile) error
+
+	// PurgePlops removes all plops created before given time and returns
+	// their number.
+	PurgePlops(context.Context, time.Time) (int64, error)
+	Stats(context.Context) (*StoreStats, error)
+
+	// Ping returns an error if the store cannot be used.
+	Ping(context.Context) error
 	Close() error
 }
 
+// PlopQuery describes which plops should be returned by ListPlops. Zero value
+// fields are ignored.
+type PlopQuery struct {
+	// OlderThan if set, returns only plops created before given time.
+	OlderThan time.Time
+	// OlderThanID if set together with OlderThan, additionally returns
+	// plops created exactly at OlderThan with an ID lower than given. This
+	// allows to use the last plop of a page as a pagination cursor.
+	OlderThanID PlopID
+	// Statuses if set, returns only plops with any of given statuses.
+	Statuses []PlopStatus
+	// AuthorID if set, returns only plops created by given account.
+	AuthorID string
+	// Content if set, returns only plops with exactly given content.
+	Content string
+	// ViewerID if set, excludes plops of authors muted or blocked by given
+	// account.
+	ViewerID string
+	// FollowedBy if set, returns only plops of authors followed by given
+	// account.
+	FollowedBy string
+	// Limit is the maximum number of returned plops.
+	Limit int
+}
+
 var (
 	ErrStore    = errors.New("store")
 	ErrNotFound = fmt.Errorf("%w: not found", ErrStore)
 )
 
 type sqlPlopStore struct {
+	// db is used for all writes. It is limited to a single connection, so
+	// that writers wait for each other in the process instead of failing
+	// with "database is locked".
 	db *sql.DB
+	// read is a pool of read only connections. In WAL mode readers are not
+	// blocked by the writer.
+	read *sql.DB
+	// path is the database file path, empty for an in-memory database.
+	path string
+
+	stmtsMu sync.Mutex
+	stmts   map[stmtKey]*sql.Stmt
+}
+
+type stmtKey struct {
+	db    *sql.DB
+	query string
 }
 
+// SQLite connection settings. Busy timeout applies to connections of other
+// processes and to the snapshot connection.
+const (
+	sqliteBusyTimeoutParam = "_busy_timeout=5000"
+	sqliteReadPoolSize     = 8
+)
+
+// OpenSQLitePlopStore returns a store using SQLite database in given file,
+// with all migrations applied. The database is switched to the WAL journal
+// mode.
+//
+// An in-memory database is not shared between connections, so if dbPath is
+// ":memory:", a single connection is used for both reads and writes.
 func OpenSQLitePlopStore(dbPath string) (PlopStore, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	if dbPath == ":memory:" {
+		db, err := sql.Open("sqlite3", dbPath+"?"+sqliteBusyTimeoutParam)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open SQLite database: %w", err)
+		}
+		db.SetMaxOpenConns(1)
+		if err := migrate(db, sqliteMigrations); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("cannot migrate database: %w", err)
+		}
+		return newSQLPlopStore(db, db, ""), nil
+	}
+
+	db, err := sql.Open("sqlite3", dbPath+"?"+sqliteBusyTimeoutParam+"&_journal_mode=WAL&_synchronous=NORMAL&_txlock=immediate")
 	if err != nil {
 		return nil, fmt.Errorf("cannot open SQLite database: %w", err)
 	}
+	db.SetMaxOpenConns(1)
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS
-		plops (
-			id BLOB PRIMARY KEY CHECK (length(id) = 16),
-			author_id TEXT NOT NULL,
-			created_at TIMESTAMP NOT NULL,
-			content TEXT NOT NULL
-		)
-	`)
-	if err != nil {
+	// Migrate before opening readers, so that WAL mode is enabled first.
+	if err := migrate(db, sqliteMigrations); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("cannot migrate database: %w", err)
 	}
 
-	return &sqlPlopStore{db: db}, nil
+	read, err := sql.Open("sqlite3", dbPath+"?"+sqliteBusyTimeoutParam+"&_query_only=true")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open SQLite database: %w", err)
+	}
+	read.SetMaxOpenConns(sqliteReadPoolSize)
+	read.SetMaxIdleConns(sqliteReadPoolSize)
+	return newSQLPlopStore(db, read, dbPath), nil
+}
+
+func newSQLPlopStore(db, read *sql.DB, path string) *sqlPlopStore {
+	return &sqlPlopStore{
+		db:    db,
+		read:  read,
+		path:  path,
+		stmts: make(map[stmtKey]*sql.Stmt),
+	}
+}
+
+// prepared returns a prepared statement of given query. Statements are
+// prepared once and reused.
+func (s *sqlPlopStore) prepared(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) {
+	key := stmtKey{db: db, query: query}
+
+	s.stmtsMu.Lock()
+	defer s.stmtsMu.Unlock()
+	if stmt, ok := s.stmts[key]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("cannot prepare statement: %w", err)
+	}
+	s.stmts[key] = stmt
+	return stmt, nil
+}
+
+// sqliteMigrations is a list of all schema changes. Each migration is applied
+// only once, in order. Never change an already released migration, only
+// append new ones.
+var sqliteMigrations = []string{
+	`
+	CREATE TABLE IF NOT EXISTS
+	plops (
+		id BLOB PRIMARY KEY CHECK (length(id) = 16),
+		author_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		content TEXT NOT NULL
+	)
+	`,
+	`
+	ALTER TABLE plops ADD COLUMN status TEXT NOT NULL DEFAULT 'published';
+	CREATE INDEX plops_status_created_at_idx ON plops(status, created_at);
+	`,
+	`
+	CREATE TABLE reports (
+		id INTEGER PRIMARY KEY,
+		plop_id BLOB NOT NULL,
+		reporter_id TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		resolved_at TIMESTAMP,
+		UNIQUE (plop_id, reporter_id)
+	);
+	CREATE INDEX reports_open_idx ON reports(created_at) WHERE resolved_at IS NULL;
+
+	CREATE TABLE moderation_actions (
+		id INTEGER PRIMARY KEY,
+		plop_id BLOB NOT NULL,
+		author_id TEXT NOT NULL,
+		moderator_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		note TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+	`,
+	`
+	CREATE TABLE account_relations (
+		account_id TEXT NOT NULL,
+		target_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (account_id, target_id, kind)
+	);
+	`,
+	`
+	CREATE TABLE follows (
+		follower_id TEXT NOT NULL,
+		followee_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (follower_id, followee_id)
+	);
+	CREATE INDEX follows_followee_idx ON follows(followee_id);
+	CREATE INDEX plops_author_created_at_idx ON plops(author_id, created_at);
+	`,
+	`
+	ALTER TABLE plops ADD COLUMN edited_at TIMESTAMP;
+	`,
+	`
+	CREATE TABLE profiles (
+		account_id TEXT NOT NULL PRIMARY KEY,
+		display_name TEXT NOT NULL DEFAULT '',
+		avatar_url TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP NOT NULL
+	);
+	`,
+	`
+	ALTER TABLE profiles ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+	ALTER TABLE profiles ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
+	ALTER TABLE profiles ADD COLUMN theme TEXT NOT NULL DEFAULT '';
+	ALTER TABLE profiles ADD COLUMN default_timeline TEXT NOT NULL DEFAULT '';
+	`,
+}
+
+// migrate applies all migrations that were not yet applied. Database
+// user_version pragma holds the number of already applied migrations.
+func migrate(db *sql.DB, migrations []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	for i := version; i < len(migrations); i++ {
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	// PRAGMA does not support placeholders.
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(migrations))); err != nil {
+		return fmt.Errorf("write schema version: %w", err)
+	}
+	return tx.Commit()
 }
 
 func (s *sqlPlopStore) Close() error {
-	return s.db.Close()
+	s.stmtsMu.Lock()
+	for key, stmt := range s.stmts {
+		_ = stmt.Close()
+		delete(s.stmts, key)
+	}
+	s.stmtsMu.Unlock()
+
+	err := s.db.Close()
+	if s.read != s.db {
+		if rerr := s.read.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+func (s *sqlPlopStore) Ping(ctx context.Context) error {
+	// Reading the schema ensures that the database file is accessible.
+	var n int
+	return s.read.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master`).Scan(&n)
 }
 
 func (s *sqlPlopStore) Plop(ctx context.Context, id PlopID) (*Plop, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT created_at, content FROM plops WHERE id = ? LIMIT 1
-	`, id)
-	p := Plop{ID: id}
-	switch err := row.Scan(&p.CreatedAt, &p.Content); {
+	stmt, err := s.prepared(ctx, s.read, `
+		SELECT `+plopColumns+` FROM plops WHERE id = ? LIMIT 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	var row plopRow
+	switch err := stmt.QueryRowContext(ctx, id).Scan(row.dest()...); {
 	case err == nil:
-		return &p, nil
+		return row.plop(), nil
 	case err == sql.ErrNoRows:
 		return nil, ErrNotFound
 	default:
@@ -69,15 +327,118 @@ func (s *sqlPlopStore) Plop(ctx context.Context, id PlopID) (*Plop, error) {
 	}
 }
 
-func (s *sqlPlopStore) Create(ctx context.Context, authorID string, content string) (PlopID, error) {
+// idsPerQuery is the maximum number of IDs in a single query. SQLite limits
+// the number of query parameters.
+const idsPerQuery = 500
+
+func (s *sqlPlopStore) PlopsByID(ctx context.Context, ids []PlopID) ([]*Plop, error) {
+	byID := make(map[string]*Plop, len(ids))
+	for start := 0; start < len(ids); start += idsPerQuery {
+		chunk := ids[start:]
+		if len(chunk) > idsPerQuery {
+			chunk = chunk[:idsPerQuery]
+		}
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		rows, err := s.read.QueryContext(ctx, `
+			SELECT `+plopColumns+` FROM plops
+			WHERE id IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)
+		`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot query plops: %w", err)
+		}
+		for rows.Next() {
+			var row plopRow
+			if err := rows.Scan(row.dest()...); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("cannot scan plop entry: %w", err)
+			}
+			byID[string(row.ID)] = row.plop()
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("cannot query plops: %w", err)
+		}
+		rows.Close()
+	}
+
+	results := make([]*Plop, 0, len(byID))
+	for _, id := range ids {
+		if p, ok := byID[string(id)]; ok {
+			results = append(results, p)
+		}
+	}
+	return results, nil
+}
+
+func (s *sqlPlopStore) Create(ctx context.Context, authorID string, content string, status PlopStatus) (PlopID, error) {
 	id := newPlopID()
 	now := time.Now().UTC()
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO plops (id, author_id, created_at, content) VALUES (?, ?, ?, ?)
-	`, id, authorID, now, content)
+	stmt, err := s.prepared(ctx, s.db, `
+		INSERT INTO plops (id, author_id, created_at, content, status) VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = stmt.ExecContext(ctx, id, authorID, now, content, status)
 	return id, err
 }
 
+func (s *sqlPlopStore) InsertPlops(ctx context.Context, plops []*Plop) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO plops (id, author_id, created_at, edited_at, content, status) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("cannot prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	var inserted int
+	for _, p := range plops {
+		var editedAt sql.NullTime
+		if !p.EditedAt.IsZero() {
+			editedAt = sql.NullTime{Time: p.EditedAt.UTC(), Valid: true}
+		}
+		res, err := stmt.ExecContext(ctx, p.ID, p.AuthorID, p.CreatedAt.UTC(), editedAt, p.Content, p.Status)
+		if err != nil {
+			return 0, fmt.Errorf("cannot insert plop %s: %w", p.ID, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("cannot get affected rows: %w", err)
+		}
+		inserted += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return inserted, nil
+}
+
+func (s *sqlPlopStore) SetPlopStatus(ctx context.Context, id PlopID, status PlopStatus) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE plops SET status = ? WHERE id = ?
+	`, status, id)
+	if err != nil {
+		return fmt.Errorf("cannot update plop: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("cannot get affected rows: %w", err)
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func newPlopID() PlopID {
 	id := make(PlopID, 16)
 	if _, err := rand.Read(id); err != nil {
@@ -86,41 +447,145 @@ func newPlopID() PlopID {
 	return id
 }
 
-func (s *sqlPlopStore) ListPlops(ctx context.Context, olderThan time.Time, limit int) ([]*Plop, error) {
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, author_id, created_at, content
+func (s *sqlPlopStore) ListPlops(ctx context.Context, q PlopQuery) ([]*Plop, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	switch {
+	case !q.OlderThan.IsZero() && q.OlderThanID != nil:
+		where = append(where, "(created_at, id) < (?, ?)")
+		args = append(args, q.OlderThan, q.OlderThanID)
+	case !q.OlderThan.IsZero():
+		where = append(where, "created_at < ?")
+		args = append(args, q.OlderThan)
+	}
+	if len(q.Statuses) != 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(q.Statuses)-1)+")")
+		for _, st := range q.Statuses {
+			args = append(args, st)
+		}
+	}
+	if q.AuthorID != "" {
+		where = append(where, "author_id = ?")
+		args = append(args, q.AuthorID)
+	}
+	if q.Content != "" {
+		where = append(where, "content = ?")
+		args = append(args, q.Content)
+	}
+	if q.ViewerID != "" {
+		where = append(where, "author_id NOT IN (SELECT target_id FROM account_relations WHERE account_id = ?)")
+		args = append(args, q.ViewerID)
+	}
+	if q.FollowedBy != "" {
+		where = append(where, "author_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)")
+		args = append(args, q.FollowedBy)
+	}
+	if len(where) == 0 {
+		where = append(where, "1")
+	}
+	args = append(args, q.Limit)
+
+	// The query depends only on which filters are used, so the number of
+	// prepared variants is small.
+	stmt, err := s.prepared(ctx, s.read, `
+		SELECT `+plopColumns+`
 		FROM plops
-		WHERE created_at < ?
-		ORDER BY created_at DESC
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY created_at DESC, id DESC
 		LIMIT ?
-	`, olderThan, limit)
-
+	`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
 		return nil, fmt.Errorf("cannot query plops: %w", err)
 	}
+	defer rows.Close()
 
-	results := make([]*Plop, 0, limit)
+	results := make([]*Plop, 0, q.Limit)
 	for rows.Next() {
-		var p Plop
-		if err := rows.Scan(&p.ID, &p.AuthorID, &p.CreatedAt, &p.Content); err != nil {
+		var row plopRow
+		if err := rows.Scan(row.dest()...); err != nil {
 			return results, fmt.Errorf("cannot scan plop entry: %w", err)
 		}
-		results = append(results, &p)
+		results = append(results, row.plop())
 	}
 
-	return results, nil
-
+	return results, rows.Err()
 }
 
 type Plop struct {
-	ID        PlopID
-	AuthorID  string
-	CreatedAt time.Time
-	Content   string
+	ID        PlopID    `json:"id"`
+	AuthorID  string    `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// EditedAt is the time of the last change of the content. It is zero if
+	// the plop was never edited.
+	EditedAt time.Time  `json:"edited_at"`
+	Content  string     `json:"content"`
+	Status   PlopStatus `json:"status"`
+}
+
+// MarshalJSON serializes the plop, omitting the edit time if the plop was
+// never edited.
+func (p *Plop) MarshalJSON() ([]byte, error) {
+	type plop Plop
+	var editedAt *time.Time
+	if !p.EditedAt.IsZero() {
+		editedAt = &p.EditedAt
+	}
+	return json.Marshal(struct {
+		*plop
+		EditedAt *time.Time `json:"edited_at,omitempty"`
+	}{
+		plop:     (*plop)(p),
+		EditedAt: editedAt,
+	})
+}
+
+// plopColumns are the plops table columns scanned by plopRow, in order.
+const plopColumns = `id, author_id, created_at, edited_at, content, status`
+
+// plopRow is a plop scanned from the database.
+type plopRow struct {
+	Plop
+	editedAt sql.NullTime
+}
+
+func (r *plopRow) dest() []interface{} {
+	return []interface{}{&r.ID, &r.AuthorID, &r.CreatedAt, &r.editedAt, &r.Content, &r.Status}
 }
 
+func (r *plopRow) plop() *Plop {
+	p := r.Plop
+	p.CreatedAt = p.CreatedAt.UTC()
+	if r.editedAt.Valid {
+		p.EditedAt = r.editedAt.Time.UTC()
+	}
+	return &p
+}
+
+// PlopStatus describes the moderation state of a plop. Only published plops
+// are visible to everyone.
+type PlopStatus string
+
+const (
+	PlopPublished PlopStatus = "published"
+	PlopHeld      PlopStatus = "held"
+	PlopRejected  PlopStatus = "rejected"
+	PlopHidden    PlopStatus = "hidden"
+)
+
 type PlopID []byte
 
 func (id PlopID) String() string {
 	return hex.EncodeToString(id)
 }
+
+// MarshalText returns the hex representation of the ID, the same as used in
+// URLs.
+func (id PlopID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
@@ -0,0 +1,138 @@
+package plopper
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StoreStats is a summary of the store content.
+type StoreStats struct {
+	// Plops is the number of plops by status.
+	Plops       map[PlopStatus]int64
+	Authors     int64
+	OpenReports int64
+	Follows     int64
+	Relations   int64
+	// Oldest and Newest are creation times of the oldest and the newest
+	// plops. Both are zero if there are no plops.
+	Oldest time.Time
+	Newest time.Time
+}
+
+func (s *sqlPlopStore) Stats(ctx context.Context) (*StoreStats, error) {
+	stats := StoreStats{Plops: make(map[PlopStatus]int64)}
+
+	rows, err := s.read.QueryContext(ctx, `
+		SELECT status, COUNT(*) FROM plops GROUP BY status
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("cannot count plops: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			status PlopStatus
+			n      int64
+		)
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("cannot scan plops count: %w", err)
+		}
+		stats.Plops[status] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot count plops: %w", err)
+	}
+
+	// Aggregates of an empty table are NULL.
+	var oldest, newest nullTime
+	err = s.read.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(DISTINCT author_id) FROM plops),
+			(SELECT COUNT(*) FROM reports WHERE resolved_at IS NULL),
+			(SELECT COUNT(*) FROM follows),
+			(SELECT COUNT(*) FROM account_relations),
+			(SELECT created_at FROM plops ORDER BY created_at ASC LIMIT 1),
+			(SELECT created_at FROM plops ORDER BY created_at DESC LIMIT 1)
+	`).Scan(&stats.Authors, &stats.OpenReports, &stats.Follows, &stats.Relations, &oldest, &newest)
+	if err != nil {
+		return nil, fmt.Errorf("cannot count store entities: %w", err)
+	}
+	stats.Oldest = oldest.Time
+	stats.Newest = newest.Time
+	return &stats, nil
+}
+
+// nullTime is a time that can be scanned from NULL. The sql.NullTime does not
+// parse the text representation that SQLite returns for subquery results.
+type nullTime struct {
+	Time time.Time
+}
+
+func (t *nullTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+	case time.Time:
+		t.Time = v
+	case string:
+		return t.parse(v)
+	case []byte:
+		return t.parse(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into time", value)
+	}
+	return nil
+}
+
+func (t *nullTime) parse(s string) error {
+	for _, layout := range sqliteTimeLayouts {
+		if tm, err := time.Parse(layout, s); err == nil {
+			t.Time = tm
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot parse %q as time", s)
+}
+
+// sqliteTimeLayouts are the formats used by the SQLite driver to serialize
+// time.
+var sqliteTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// PurgePlops permanently removes all plops created before given time, together
+// with their reports. Moderation actions are kept for the audit. It returns
+// the number of removed plops.
+func (s *sqlPlopStore) PurgePlops(ctx context.Context, olderThan time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM reports WHERE plop_id IN (SELECT id FROM plops WHERE created_at < ?)
+	`, olderThan.UTC()); err != nil {
+		return 0, fmt.Errorf("cannot delete reports: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM plops WHERE created_at < ?
+	`, olderThan.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("cannot delete plops: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("cannot get affected rows: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,92 @@
+package plopper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func (s *sqlPlopStore) Follow(ctx context.Context, followerID, followeeID string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO follows (follower_id, followee_id, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, followerID, followeeID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("cannot insert follow: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlPlopStore) Unfollow(ctx context.Context, followerID, followeeID string) error {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM follows WHERE follower_id = ? AND followee_id = ?
+	`, followerID, followeeID)
+	if err != nil {
+		return fmt.Errorf("cannot delete follow: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("cannot get affected rows: %w", err)
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlPlopStore) IsFollowing(ctx context.Context, followerID, followeeID string) (bool, error) {
+	var exists bool
+	err := s.read.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM follows WHERE follower_id = ? AND followee_id = ?
+		)
+	`, followerID, followeeID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("cannot query follow: %w", err)
+	}
+	return exists, nil
+}
+
+// ListFollowers returns IDs of accounts following given account, most recent
+// first.
+func (s *sqlPlopStore) ListFollowers(ctx context.Context, accountID string, limit int) ([]string, error) {
+	rows, err := s.read.QueryContext(ctx, `
+		SELECT follower_id FROM follows
+		WHERE followee_id = ?
+		ORDER BY created_at DESC
+		LIMIT ?
+	`, accountID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query followers: %w", err)
+	}
+	return scanAccountIDs(rows)
+}
+
+// ListFollowing returns IDs of accounts followed by given account, most recent
+// first.
+func (s *sqlPlopStore) ListFollowing(ctx context.Context, accountID string, limit int) ([]string, error) {
+	rows, err := s.read.QueryContext(ctx, `
+		SELECT followee_id FROM follows
+		WHERE follower_id = ?
+		ORDER BY created_at DESC
+		LIMIT ?
+	`, accountID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query following: %w", err)
+	}
+	return scanAccountIDs(rows)
+}
+
+func scanAccountIDs(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return ids, fmt.Errorf("cannot scan account ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
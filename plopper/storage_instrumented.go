@@ -0,0 +1,198 @@
+package plopper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/husio/plopper/metrics"
+	"github.com/husio/plopper/tracing"
+)
+
+var storeCallDuration = metrics.NewHistogram(
+	"plopper_store_call_duration_seconds",
+	"Duration of plop store calls.",
+	nil, "method", "outcome")
+
+// InstrumentPlopStore returns a PlopStore that measures the duration and the
+// outcome of every call to given store. Each call is traced as a separate
+// span.
+func InstrumentPlopStore(s PlopStore) PlopStore {
+	return &instrumentedPlopStore{next: s}
+}
+
+type instrumentedPlopStore struct {
+	next PlopStore
+}
+
+// observe starts measuring a call. Returned context carries the span of the
+// call and must be passed to the store. Returned function must be called with
+// the error returned by the call once it is done.
+func (s *instrumentedPlopStore) observe(ctx context.Context, method string) (context.Context, func(*error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "store."+method)
+	return ctx, func(errp *error) {
+		outcome := "ok"
+		switch err := *errp; {
+		case err == nil:
+		case errors.Is(err, ErrNotFound):
+			outcome = "not_found"
+		default:
+			outcome = "error"
+		}
+		storeCallDuration.Observe(time.Since(start).Seconds(), method, outcome)
+		span.SetAttribute("outcome", outcome)
+		if outcome == "error" {
+			span.RecordError(*errp)
+		}
+		span.End()
+	}
+}
+
+func (s *instrumentedPlopStore) Create(ctx context.Context, authorID, content string, status PlopStatus) (_ PlopID, err error) {
+	ctx, done := s.observe(ctx, "Create")
+	defer done(&err)
+	return s.next.Create(ctx, authorID, content, status)
+}
+
+func (s *instrumentedPlopStore) ListPlops(ctx context.Context, q PlopQuery) (_ []*Plop, err error) {
+	ctx, done := s.observe(ctx, "ListPlops")
+	defer done(&err)
+	return s.next.ListPlops(ctx, q)
+}
+
+func (s *instrumentedPlopStore) Plop(ctx context.Context, id PlopID) (_ *Plop, err error) {
+	ctx, done := s.observe(ctx, "Plop")
+	defer done(&err)
+	return s.next.Plop(ctx, id)
+}
+
+func (s *instrumentedPlopStore) InsertPlops(ctx context.Context, plops []*Plop) (_ int, err error) {
+	ctx, done := s.observe(ctx, "InsertPlops")
+	defer done(&err)
+	return s.next.InsertPlops(ctx, plops)
+}
+
+func (s *instrumentedPlopStore) PlopsByID(ctx context.Context, ids []PlopID) (_ []*Plop, err error) {
+	ctx, done := s.observe(ctx, "PlopsByID")
+	defer done(&err)
+	return s.next.PlopsByID(ctx, ids)
+}
+
+func (s *instrumentedPlopStore) SetPlopStatus(ctx context.Context, id PlopID, status PlopStatus) (err error) {
+	ctx, done := s.observe(ctx, "SetPlopStatus")
+	defer done(&err)
+	return s.next.SetPlopStatus(ctx, id, status)
+}
+
+func (s *instrumentedPlopStore) CreateReport(ctx context.Context, id PlopID, reporterID, reason string) (err error) {
+	ctx, done := s.observe(ctx, "CreateReport")
+	defer done(&err)
+	return s.next.CreateReport(ctx, id, reporterID, reason)
+}
+
+func (s *instrumentedPlopStore) ListOpenReports(ctx context.Context, limit int) (_ []*Report, err error) {
+	ctx, done := s.observe(ctx, "ListOpenReports")
+	defer done(&err)
+	return s.next.ListOpenReports(ctx, limit)
+}
+
+func (s *instrumentedPlopStore) ApplyModerationAction(ctx context.Context, a *ModerationAction) (err error) {
+	ctx, done := s.observe(ctx, "ApplyModerationAction")
+	defer done(&err)
+	return s.next.ApplyModerationAction(ctx, a)
+}
+
+func (s *instrumentedPlopStore) ListModerationActions(ctx context.Context, limit int) (_ []*ModerationAction, err error) {
+	ctx, done := s.observe(ctx, "ListModerationActions")
+	defer done(&err)
+	return s.next.ListModerationActions(ctx, limit)
+}
+
+func (s *instrumentedPlopStore) CreateRelation(ctx context.Context, accountID, targetID string, kind RelationKind) (err error) {
+	ctx, done := s.observe(ctx, "CreateRelation")
+	defer done(&err)
+	return s.next.CreateRelation(ctx, accountID, targetID, kind)
+}
+
+func (s *instrumentedPlopStore) DeleteRelation(ctx context.Context, accountID, targetID string, kind RelationKind) (err error) {
+	ctx, done := s.observe(ctx, "DeleteRelation")
+	defer done(&err)
+	return s.next.DeleteRelation(ctx, accountID, targetID, kind)
+}
+
+func (s *instrumentedPlopStore) ListRelations(ctx context.Context, accountID string) (_ []*Relation, err error) {
+	ctx, done := s.observe(ctx, "ListRelations")
+	defer done(&err)
+	return s.next.ListRelations(ctx, accountID)
+}
+
+func (s *instrumentedPlopStore) HasRelation(ctx context.Context, accountID, targetID string, kind RelationKind) (_ bool, err error) {
+	ctx, done := s.observe(ctx, "HasRelation")
+	defer done(&err)
+	return s.next.HasRelation(ctx, accountID, targetID, kind)
+}
+
+func (s *instrumentedPlopStore) Follow(ctx context.Context, followerID, followeeID string) (err error) {
+	ctx, done := s.observe(ctx, "Follow")
+	defer done(&err)
+	return s.next.Follow(ctx, followerID, followeeID)
+}
+
+func (s *instrumentedPlopStore) Unfollow(ctx context.Context, followerID, followeeID string) (err error) {
+	ctx, done := s.observe(ctx, "Unfollow")
+	defer done(&err)
+	return s.next.Unfollow(ctx, followerID, followeeID)
+}
+
+func (s *instrumentedPlopStore) IsFollowing(ctx context.Context, followerID, followeeID string) (_ bool, err error) {
+	ctx, done := s.observe(ctx, "IsFollowing")
+	defer done(&err)
+	return s.next.IsFollowing(ctx, followerID, followeeID)
+}
+
+func (s *instrumentedPlopStore) ListFollowers(ctx context.Context, accountID string, limit int) (_ []string, err error) {
+	ctx, done := s.observe(ctx, "ListFollowers")
+	defer done(&err)
+	return s.next.ListFollowers(ctx, accountID, limit)
+}
+
+func (s *instrumentedPlopStore) ListFollowing(ctx context.Context, accountID string, limit int) (_ []string, err error) {
+	ctx, done := s.observe(ctx, "ListFollowing")
+	defer done(&err)
+	return s.next.ListFollowing(ctx, accountID, limit)
+}
+
+func (s *instrumentedPlopStore) PurgePlops(ctx context.Context, olderThan time.Time) (_ int64, err error) {
+	ctx, done := s.observe(ctx, "PurgePlops")
+	defer done(&err)
+	return s.next.PurgePlops(ctx, olderThan)
+}
+
+func (s *instrumentedPlopStore) Profiles(ctx context.Context, accountIDs []string) (_ map[string]*Profile, err error) {
+	ctx, done := s.observe(ctx, "Profiles")
+	defer done(&err)
+	return s.next.Profiles(ctx, accountIDs)
+}
+
+func (s *instrumentedPlopStore) SaveProfile(ctx context.Context, p *Profile) (err error) {
+	ctx, done := s.observe(ctx, "SaveProfile")
+	defer done(&err)
+	return s.next.SaveProfile(ctx, p)
+}
+
+func (s *instrumentedPlopStore) Stats(ctx context.Context) (_ *StoreStats, err error) {
+	ctx, done := s.observe(ctx, "Stats")
+	defer done(&err)
+	return s.next.Stats(ctx)
+}
+
+func (s *instrumentedPlopStore) Ping(ctx context.Context) (err error) {
+	ctx, done := s.observe(ctx, "Ping")
+	defer done(&err)
+	return s.next.Ping(ctx)
+}
+
+func (s *instrumentedPlopStore) Close() error {
+	return s.next.Close()
+}
@@ -0,0 +1,104 @@
+package plopper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	// Time zones chosen by users must be available regardless of the
+	// system the server runs on.
+	_ "time/tzdata"
+)
+
+// Profile describes how an account is presented to others, together with
+// preferences of the account owner.
+type Profile struct {
+	AccountID   string `json:"account_id"`
+	DisplayName string `json:"display_name"`
+	// AvatarURL is the address of the account image. It can be empty.
+	AvatarURL string `json:"avatar_url"`
+	// Bio is a short description of the account, written by its owner.
+	Bio string `json:"bio"`
+	// Timezone is the IANA name of the time zone that times are presented
+	// in. Empty means UTC.
+	Timezone string `json:"timezone"`
+	// Theme is the color theme of the interface. Empty means the theme
+	// preferred by the browser.
+	Theme string `json:"theme"`
+	// DefaultTimeline is the timeline shown when none is selected. Empty
+	// means the last selected timeline.
+	DefaultTimeline string    `json:"default_timeline"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+const (
+	themeLight = "light"
+	themeDark  = "dark"
+)
+
+// Name returns the name that the account should be presented with.
+func (p *Profile) Name() string {
+	if p.DisplayName != "" {
+		return p.DisplayName
+	}
+	return p.AccountID
+}
+
+func (s *sqlPlopStore) Profiles(ctx context.Context, accountIDs []string) (map[string]*Profile, error) {
+	profiles := make(map[string]*Profile, len(accountIDs))
+	for start := 0; start < len(accountIDs); start += idsPerQuery {
+		chunk := accountIDs[start:]
+		if len(chunk) > idsPerQuery {
+			chunk = chunk[:idsPerQuery]
+		}
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		rows, err := s.read.QueryContext(ctx, `
+			SELECT account_id, display_name, avatar_url, bio, timezone, theme, default_timeline, updated_at
+			FROM profiles
+			WHERE account_id IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)
+		`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot query profiles: %w", err)
+		}
+		for rows.Next() {
+			var p Profile
+			if err := rows.Scan(&p.AccountID, &p.DisplayName, &p.AvatarURL, &p.Bio, &p.Timezone, &p.Theme, &p.DefaultTimeline, &p.UpdatedAt); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("cannot scan profile entry: %w", err)
+			}
+			p.UpdatedAt = p.UpdatedAt.UTC()
+			profiles[p.AccountID] = &p
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("cannot query profiles: %w", err)
+		}
+		rows.Close()
+	}
+	return profiles, nil
+}
+
+// SaveProfile creates or replaces the profile of an account. UpdatedAt is set
+// to the current time.
+func (s *sqlPlopStore) SaveProfile(ctx context.Context, p *Profile) error {
+	p.UpdatedAt = time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO profiles (account_id, display_name, avatar_url, bio, timezone, theme, default_timeline, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (account_id) DO UPDATE SET
+			display_name = excluded.display_name,
+			avatar_url = excluded.avatar_url,
+			bio = excluded.bio,
+			timezone = excluded.timezone,
+			theme = excluded.theme,
+			default_timeline = excluded.default_timeline,
+			updated_at = excluded.updated_at
+	`, p.AccountID, p.DisplayName, p.AvatarURL, p.Bio, p.Timezone, p.Theme, p.DefaultTimeline, p.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("cannot save profile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package plopper
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Relation is a decision of an account about another account.
+type Relation struct {
+	AccountID string       `json:"account_id"`
+	TargetID  string       `json:"target_id"`
+	Kind      RelationKind `json:"kind"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+type RelationKind string
+
+const (
+	// RelationMute hides all plops of the target from the account.
+	RelationMute RelationKind = "mute"
+	// RelationBlock hides all plops of the target from the account and
+	// prevents the target from interacting with the account.
+	RelationBlock RelationKind = "block"
+)
+
+// Valid returns true if k is a known relation kind.
+func (k RelationKind) Valid() bool {
+	return k == RelationMute || k == RelationBlock
+}
+
+func (s *sqlPlopStore) CreateRelation(ctx context.Context, accountID, targetID string, kind RelationKind) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO account_relations (account_id, target_id, kind, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, accountID, targetID, kind, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("cannot insert relation: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlPlopStore) DeleteRelation(ctx context.Context, accountID, targetID string, kind RelationKind) error {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM account_relations WHERE account_id = ? AND target_id = ? AND kind = ?
+	`, accountID, targetID, kind)
+	if err != nil {
+		return fmt.Errorf("cannot delete relation: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("cannot get affected rows: %w", err)
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlPlopStore) ListRelations(ctx context.Context, accountID string) ([]*Relation, error) {
+	rows, err := s.read.QueryContext(ctx, `
+		SELECT account_id, target_id, kind, created_at
+		FROM account_relations
+		WHERE account_id = ?
+		ORDER BY created_at DESC
+	`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query relations: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*Relation
+	for rows.Next() {
+		var r Relation
+		if err := rows.Scan(&r.AccountID, &r.TargetID, &r.Kind, &r.CreatedAt); err != nil {
+			return results, fmt.Errorf("cannot scan relation entry: %w", err)
+		}
+		results = append(results, &r)
+	}
+	return results, rows.Err()
+}
+
+func (s *sqlPlopStore) HasRelation(ctx context.Context, accountID, targetID string, kind RelationKind) (bool, error) {
+	var exists bool
+	err := s.read.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM account_relations WHERE account_id = ? AND target_id = ? AND kind = ?
+		)
+	`, accountID, targetID, kind).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("cannot query relation: %w", err)
+	}
+	return exists, nil
+}
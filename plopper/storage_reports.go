@@ -0,0 +1,186 @@
+package plopper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Report is a complaint about a plop, submitted by a reader.
+type Report struct {
+	ID         int64     `json:"id"`
+	Plop       *Plop     `json:"plop"`
+	ReporterID string    `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ModerationAction is an entry of the moderation audit trail.
+type ModerationAction struct {
+	ID          int64                `json:"id"`
+	PlopID      PlopID               `json:"plop_id"`
+	AuthorID    string               `json:"author_id"`
+	ModeratorID string               `json:"moderator_id"`
+	Action      ModerationActionType `json:"action"`
+	Note        string               `json:"note"`
+	CreatedAt   time.Time            `json:"created_at"`
+}
+
+type ModerationActionType string
+
+const (
+	// ActionApprove publishes a plop.
+	ActionApprove ModerationActionType = "approve"
+	// ActionReject rejects a plop that awaits moderation.
+	ActionReject ModerationActionType = "reject"
+	// ActionHide hides a plop from everyone but moderators.
+	ActionHide ModerationActionType = "hide"
+	// ActionDelete permanently removes a plop.
+	ActionDelete ModerationActionType = "delete"
+	// ActionWarn records a warning for the author of a plop.
+	ActionWarn ModerationActionType = "warn"
+	// ActionDismiss closes all reports of a plop without changing it.
+	ActionDismiss ModerationActionType = "dismiss"
+)
+
+// statusChange returns the status that a plop gets after the action is
+// applied. False is returned if the action does not change the status.
+func (a ModerationActionType) statusChange() (PlopStatus, bool) {
+	switch a {
+	case ActionApprove:
+		return PlopPublished, true
+	case ActionReject:
+		return PlopRejected, true
+	case ActionHide:
+		return PlopHidden, true
+	}
+	return "", false
+}
+
+// Valid returns true if a is a known moderation action.
+func (a ModerationActionType) Valid() bool {
+	switch a {
+	case ActionApprove, ActionReject, ActionHide, ActionDelete, ActionWarn, ActionDismiss:
+		return true
+	}
+	return false
+}
+
+func (s *sqlPlopStore) CreateReport(ctx context.Context, plopID PlopID, reporterID, reason string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO reports (plop_id, reporter_id, reason, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (plop_id, reporter_id) DO UPDATE SET reason = excluded.reason
+		WHERE resolved_at IS NULL
+	`, plopID, reporterID, reason, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("cannot insert report: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlPlopStore) ListOpenReports(ctx context.Context, limit int) ([]*Report, error) {
+	rows, err := s.read.QueryContext(ctx, `
+		SELECT r.id, r.reporter_id, r.reason, r.created_at,
+			p.id, p.author_id, p.created_at, p.edited_at, p.content, p.status
+		FROM reports r
+			INNER JOIN plops p ON r.plop_id = p.id
+		WHERE r.resolved_at IS NULL
+		ORDER BY r.created_at ASC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query reports: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*Report
+	for rows.Next() {
+		var (
+			r    Report
+			plop plopRow
+		)
+		dest := append([]interface{}{&r.ID, &r.ReporterID, &r.Reason, &r.CreatedAt}, plop.dest()...)
+		if err := rows.Scan(dest...); err != nil {
+			return results, fmt.Errorf("cannot scan report entry: %w", err)
+		}
+		r.Plop = plop.plop()
+		results = append(results, &r)
+	}
+	return results, rows.Err()
+}
+
+// ApplyModerationAction changes the plop according to the action, resolves
+// all its open reports and records the action in the audit trail. Action ID,
+// AuthorID and CreatedAt are set by this method.
+func (s *sqlPlopStore) ApplyModerationAction(ctx context.Context, a *ModerationAction) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	switch err := tx.QueryRowContext(ctx, `SELECT author_id FROM plops WHERE id = ?`, a.PlopID).Scan(&a.AuthorID); {
+	case err == nil:
+		// All good.
+	case err == sql.ErrNoRows:
+		return ErrNotFound
+	default:
+		return fmt.Errorf("cannot get plop: %w", err)
+	}
+
+	if status, ok := a.Action.statusChange(); ok {
+		if _, err := tx.ExecContext(ctx, `UPDATE plops SET status = ? WHERE id = ?`, status, a.PlopID); err != nil {
+			return fmt.Errorf("cannot update plop: %w", err)
+		}
+	}
+	if a.Action == ActionDelete {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM plops WHERE id = ?`, a.PlopID); err != nil {
+			return fmt.Errorf("cannot delete plop: %w", err)
+		}
+	}
+
+	a.CreatedAt = time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE reports SET resolved_at = ? WHERE plop_id = ? AND resolved_at IS NULL
+	`, a.CreatedAt, a.PlopID); err != nil {
+		return fmt.Errorf("cannot resolve reports: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO moderation_actions (plop_id, author_id, moderator_id, action, note, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, a.PlopID, a.AuthorID, a.ModeratorID, a.Action, a.Note, a.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("cannot insert moderation action: %w", err)
+	}
+	if a.ID, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("cannot get moderation action ID: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlPlopStore) ListModerationActions(ctx context.Context, limit int) ([]*ModerationAction, error) {
+	rows, err := s.read.QueryContext(ctx, `
+		SELECT id, plop_id, author_id, moderator_id, action, note, created_at
+		FROM moderation_actions
+		ORDER BY id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query moderation actions: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*ModerationAction
+	for rows.Next() {
+		var a ModerationAction
+		if err := rows.Scan(&a.ID, &a.PlopID, &a.AuthorID, &a.ModeratorID, &a.Action, &a.Note, &a.CreatedAt); err != nil {
+			return results, fmt.Errorf("cannot scan moderation action entry: %w", err)
+		}
+		results = append(results, &a)
+	}
+	return results, rows.Err()
+}
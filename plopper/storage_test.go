@@ -3,6 +3,11 @@ package plopper
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -16,7 +21,7 @@ func TestCreateAndList(t *testing.T) {
 	}
 	defer store.Close()
 
-	firstID, err := store.Create(ctx, "000000000000001", "first")
+	firstID, err := store.Create(ctx, "000000000000001", "first", PlopPublished)
 	if err != nil {
 		t.Fatalf("cannot create first plop: %s", err)
 	}
@@ -28,7 +33,7 @@ func TestCreateAndList(t *testing.T) {
 		t.Fatalf("unexpected plop: %+v", first)
 	}
 
-	secondID, err := store.Create(ctx, "000000000000001", "second")
+	secondID, err := store.Create(ctx, "000000000000001", "second", PlopPublished)
 	if err != nil {
 		t.Fatalf("cannot create second plop: %s", err)
 	}
@@ -40,7 +45,7 @@ func TestCreateAndList(t *testing.T) {
 		t.Fatalf("unexpected plop: %+v", second)
 	}
 
-	plops, err := store.ListPlops(ctx, time.Now().UTC(), 10)
+	plops, err := store.ListPlops(ctx, PlopQuery{OlderThan: time.Now().UTC(), Limit: 10})
 	if err != nil {
 		t.Fatalf("cannot list plops: %s", err)
 	}
@@ -51,3 +56,377 @@ func TestCreateAndList(t *testing.T) {
 		t.Fatalf("invalid listing order: 0:%s 1:%s", plops[0].ID, plops[1].ID)
 	}
 }
+
+func TestReportsAndModerationActions(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+
+	id, err := store.Create(ctx, "author", "offensive", PlopPublished)
+	if err != nil {
+		t.Fatalf("cannot create plop: %s", err)
+	}
+
+	if err := store.CreateReport(ctx, id, "reader-1", "rude"); err != nil {
+		t.Fatalf("cannot report: %s", err)
+	}
+	if err := store.CreateReport(ctx, id, "reader-1", "very rude"); err != nil {
+		t.Fatalf("cannot report again: %s", err)
+	}
+	if err := store.CreateReport(ctx, id, "reader-2", "spam"); err != nil {
+		t.Fatalf("cannot report: %s", err)
+	}
+
+	reports, err := store.ListOpenReports(ctx, 10)
+	if err != nil {
+		t.Fatalf("cannot list reports: %s", err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("want 2 reports, got %d", len(reports))
+	}
+	if reports[0].Reason != "very rude" || !bytes.Equal(reports[0].Plop.ID, id) {
+		t.Fatalf("unexpected report: %+v", reports[0])
+	}
+
+	action := ModerationAction{PlopID: id, ModeratorID: "moderator", Action: ActionHide, Note: "rude"}
+	if err := store.ApplyModerationAction(ctx, &action); err != nil {
+		t.Fatalf("cannot apply moderation action: %s", err)
+	}
+	if action.AuthorID != "author" {
+		t.Fatalf("want author ID set, got %q", action.AuthorID)
+	}
+
+	if p, err := store.Plop(ctx, id); err != nil {
+		t.Fatalf("cannot get plop: %s", err)
+	} else if p.Status != PlopHidden {
+		t.Fatalf("want plop hidden, got %q", p.Status)
+	}
+	if reports, err := store.ListOpenReports(ctx, 10); err != nil {
+		t.Fatalf("cannot list reports: %s", err)
+	} else if len(reports) != 0 {
+		t.Fatalf("want all reports resolved, got %d", len(reports))
+	}
+
+	if err := store.ApplyModerationAction(ctx, &ModerationAction{PlopID: id, ModeratorID: "moderator", Action: ActionDelete}); err != nil {
+		t.Fatalf("cannot delete plop: %s", err)
+	}
+	if _, err := store.Plop(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want plop deleted, got %v", err)
+	}
+	if err := store.ApplyModerationAction(ctx, &ModerationAction{PlopID: id, ModeratorID: "moderator", Action: ActionWarn}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound for a deleted plop, got %v", err)
+	}
+
+	actions, err := store.ListModerationActions(ctx, 10)
+	if err != nil {
+		t.Fatalf("cannot list moderation actions: %s", err)
+	}
+	if len(actions) != 2 || actions[0].Action != ActionDelete || actions[1].Action != ActionHide {
+		t.Fatalf("unexpected audit trail: %+v", actions)
+	}
+}
+
+func TestRelationsExcludePlops(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+
+	for _, author := range []string{"alice", "bob", "carol"} {
+		if _, err := store.Create(ctx, author, "hello from "+author, PlopPublished); err != nil {
+			t.Fatalf("cannot create plop: %s", err)
+		}
+	}
+
+	if err := store.CreateRelation(ctx, "viewer", "alice", RelationMute); err != nil {
+		t.Fatalf("cannot mute: %s", err)
+	}
+	if err := store.CreateRelation(ctx, "viewer", "bob", RelationBlock); err != nil {
+		t.Fatalf("cannot block: %s", err)
+	}
+	if err := store.CreateRelation(ctx, "viewer", "bob", RelationBlock); err != nil {
+		t.Fatalf("cannot block twice: %s", err)
+	}
+
+	plops, err := store.ListPlops(ctx, PlopQuery{ViewerID: "viewer", Limit: 10})
+	if err != nil {
+		t.Fatalf("cannot list plops: %s", err)
+	}
+	if len(plops) != 1 || plops[0].AuthorID != "carol" {
+		t.Fatalf("want only carol's plop, got %+v", plops)
+	}
+
+	if blocked, err := store.HasRelation(ctx, "viewer", "bob", RelationBlock); err != nil || !blocked {
+		t.Fatalf("want bob blocked, got %v, %v", blocked, err)
+	}
+	if v, err := BlockedMentionFilter(store).Moderate(ctx, &Plop{AuthorID: "bob", Content: "hey @viewer"}); err != nil {
+		t.Fatalf("cannot moderate: %s", err)
+	} else if v.Status != PlopRejected {
+		t.Fatalf("want mention of a blocker rejected, got %q", v.Status)
+	}
+
+	if err := store.DeleteRelation(ctx, "viewer", "bob", RelationBlock); err != nil {
+		t.Fatalf("cannot unblock: %s", err)
+	}
+	if err := store.DeleteRelation(ctx, "viewer", "bob", RelationBlock); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+	if relations, err := store.ListRelations(ctx, "viewer"); err != nil {
+		t.Fatalf("cannot list relations: %s", err)
+	} else if len(relations) != 1 || relations[0].TargetID != "alice" {
+		t.Fatalf("unexpected relations: %+v", relations)
+	}
+}
+
+func TestFollowingTimelinePagination(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 5; i++ {
+		for _, author := range []string{"alice", "bob", "carol"} {
+			if _, err := store.Create(ctx, author, fmt.Sprintf("%s %d", author, i), PlopPublished); err != nil {
+				t.Fatalf("cannot create plop: %s", err)
+			}
+		}
+	}
+	for _, followee := range []string{"alice", "carol"} {
+		if err := store.Follow(ctx, "viewer", followee); err != nil {
+			t.Fatalf("cannot follow: %s", err)
+		}
+	}
+
+	if following, err := store.IsFollowing(ctx, "viewer", "alice"); err != nil || !following {
+		t.Fatalf("want alice followed, got %v, %v", following, err)
+	}
+	if followers, err := store.ListFollowers(ctx, "carol", 10); err != nil {
+		t.Fatalf("cannot list followers: %s", err)
+	} else if len(followers) != 1 || followers[0] != "viewer" {
+		t.Fatalf("unexpected followers: %v", followers)
+	}
+
+	var (
+		seen = make(map[string]bool)
+		q    = PlopQuery{FollowedBy: "viewer", Limit: 3}
+	)
+	for page := 0; ; page++ {
+		plops, err := store.ListPlops(ctx, q)
+		if err != nil {
+			t.Fatalf("cannot list plops: %s", err)
+		}
+		if len(plops) == 0 {
+			break
+		}
+		for _, p := range plops {
+			if p.AuthorID == "bob" {
+				t.Fatalf("plop of not followed author returned: %+v", p)
+			}
+			if seen[p.ID.String()] {
+				t.Fatalf("plop returned twice: %+v", p)
+			}
+			seen[p.ID.String()] = true
+		}
+		last := plops[len(plops)-1]
+		q.OlderThan, q.OlderThanID = last.CreatedAt, last.ID
+	}
+	if len(seen) != 10 {
+		t.Fatalf("want 10 plops, got %d", len(seen))
+	}
+}
+
+func TestPurgeAndStats(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+
+	stats, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats of an empty store: %s", err)
+	}
+	if len(stats.Plops) != 0 || !stats.Oldest.IsZero() {
+		t.Fatalf("unexpected empty store stats: %+v", stats)
+	}
+
+	oldID, err := store.Create(ctx, "alice", "old plop", PlopPublished)
+	if err != nil {
+		t.Fatalf("cannot create plop: %s", err)
+	}
+	if err := store.CreateReport(ctx, oldID, "bob", "old"); err != nil {
+		t.Fatalf("cannot report plop: %s", err)
+	}
+	cutoff := time.Now()
+	time.Sleep(time.Millisecond)
+	newID, err := store.Create(ctx, "bob", "new plop", PlopHeld)
+	if err != nil {
+		t.Fatalf("cannot create plop: %s", err)
+	}
+
+	stats, err = store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats: %s", err)
+	}
+	if stats.Plops[PlopPublished] != 1 || stats.Plops[PlopHeld] != 1 || stats.Authors != 2 || stats.OpenReports != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if stats.Oldest.After(stats.Newest) || stats.Oldest.IsZero() {
+		t.Fatalf("unexpected oldest and newest: %s %s", stats.Oldest, stats.Newest)
+	}
+
+	if n, err := store.PurgePlops(ctx, cutoff); err != nil || n != 1 {
+		t.Fatalf("want one plop purged, got %d, %v", n, err)
+	}
+	if _, err := store.Plop(ctx, oldID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want old plop purged, got %v", err)
+	}
+	if _, err := store.Plop(ctx, newID); err != nil {
+		t.Fatalf("want new plop kept, got %v", err)
+	}
+	if reports, err := store.ListOpenReports(ctx, 10); err != nil || len(reports) != 0 {
+		t.Fatalf("want reports of purged plops removed, got %d, %v", len(reports), err)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := OpenSQLitePlopStore(filepath.Join(t.TempDir(), "plopper.sqlite3"))
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+
+	var mode string
+	if err := store.(*sqlPlopStore).read.QueryRowContext(ctx, `PRAGMA journal_mode`).Scan(&mode); err != nil {
+		t.Fatalf("cannot read journal mode: %s", err)
+	}
+	if mode != "wal" {
+		t.Fatalf("want WAL journal mode, got %q", mode)
+	}
+
+	const (
+		workers = 16
+		perWork = 25
+	)
+	var wg sync.WaitGroup
+	errc := make(chan error, workers*perWork*2)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			author := fmt.Sprintf("author-%d", w)
+			for i := 0; i < perWork; i++ {
+				if _, err := store.Create(ctx, author, fmt.Sprintf("plop %d", i), PlopPublished); err != nil {
+					errc <- fmt.Errorf("create: %w", err)
+				}
+				if _, err := store.ListPlops(ctx, PlopQuery{AuthorID: author, Limit: 10}); err != nil {
+					errc <- fmt.Errorf("list: %w", err)
+				}
+				if err := store.Follow(ctx, author, fmt.Sprintf("author-%d", i%workers)); err != nil {
+					errc <- fmt.Errorf("follow: %w", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+
+	plops, err := store.ListPlops(ctx, PlopQuery{Limit: 2 * workers * perWork})
+	if err != nil {
+		t.Fatalf("cannot list plops: %s", err)
+	}
+	if len(plops) != workers*perWork {
+		t.Fatalf("want %d plops, got %d", workers*perWork, len(plops))
+	}
+}
+
+// TestPlopFieldsRoundTrip ensures that every read method returns complete
+// plops. A new Plop field must be set in the fixture, otherwise the test
+// fails.
+func TestPlopFieldsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+
+	want := &Plop{
+		ID:        PlopID(bytes.Repeat([]byte{0xab}, 16)),
+		AuthorID:  "alice",
+		CreatedAt: time.Date(2021, 3, 4, 5, 6, 7, 8000, time.UTC),
+		EditedAt:  time.Date(2021, 3, 5, 1, 2, 3, 4000, time.UTC),
+		Content:   "complete plop",
+		Status:    PlopHidden,
+	}
+	fixture := reflect.ValueOf(want).Elem()
+	for i := 0; i < fixture.NumField(); i++ {
+		if fixture.Field(i).IsZero() {
+			t.Fatalf("fixture field %s must be set", fixture.Type().Field(i).Name)
+		}
+	}
+
+	if n, err := store.InsertPlops(ctx, []*Plop{want}); err != nil || n != 1 {
+		t.Fatalf("cannot insert plop: %d, %v", n, err)
+	}
+	if err := store.CreateReport(ctx, want.ID, "bob", "test"); err != nil {
+		t.Fatalf("cannot report plop: %s", err)
+	}
+
+	assertEqual := func(method string, got *Plop) {
+		t.Helper()
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s returned a different plop:\nwant %+v\n got %+v", method, want, got)
+		}
+	}
+
+	if got, err := store.Plop(ctx, want.ID); err != nil {
+		t.Errorf("Plop: %s", err)
+	} else {
+		assertEqual("Plop", got)
+	}
+
+	if got, err := store.ListPlops(ctx, PlopQuery{Limit: 10}); err != nil || len(got) != 1 {
+		t.Errorf("ListPlops: %d, %v", len(got), err)
+	} else {
+		assertEqual("ListPlops", got[0])
+	}
+
+	if reports, err := store.ListOpenReports(ctx, 10); err != nil || len(reports) != 1 {
+		t.Errorf("ListOpenReports: %d, %v", len(reports), err)
+	} else {
+		assertEqual("ListOpenReports", reports[0].Plop)
+	}
+
+	otherID, err := store.Create(ctx, "bob", "other", PlopPublished)
+	if err != nil {
+		t.Fatalf("cannot create plop: %s", err)
+	}
+	missingID := PlopID(bytes.Repeat([]byte{0x01}, 16))
+	got, err := store.PlopsByID(ctx, []PlopID{otherID, missingID, want.ID})
+	if err != nil || len(got) != 2 {
+		t.Fatalf("PlopsByID: %d, %v", len(got), err)
+	}
+	if !bytes.Equal(got[0].ID, otherID) || !got[0].EditedAt.IsZero() {
+		t.Errorf("PlopsByID: unexpected first plop %+v", got[0])
+	}
+	assertEqual("PlopsByID", got[1])
+}
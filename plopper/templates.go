@@ -0,0 +1,299 @@
+package plopper
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/husio/plopper/i18n"
+)
+
+var (
+	//go:embed templates
+	templatesRaw embed.FS
+	// embeddedTemplates are used unless the application is configured to
+	// load templates from a directory. They must not be replaced.
+	embeddedTemplates = mustLoadTemplates(templatesRaw, "templates")
+)
+
+// templateSet holds a template for each page. Every page is executed as the
+// "layout" template, which includes the "content" template defined by the
+// page. All partials are available to the layout and to each page.
+//
+//	layout.html           base layout, defines "layout"
+//	partials/<name>.html  templates shared by pages
+//	pages/<name>.html     page templates, each defines "content"
+type templateSet struct {
+	fsys fs.FS
+	// reload is true if templates are parsed again whenever any of the
+	// files changes. This is meant for development only.
+	reload bool
+
+	mu sync.Mutex
+	// version describes the state of the files the pages were parsed
+	// from. It is only tracked if reload is true.
+	version string
+	// generation is incremented whenever templates are parsed again, so
+	// that renderers of replaced templates are not reused.
+	generation int
+	pages      map[string]*template.Template
+	// partials holds the layout and all partials, without any page.
+	partials *template.Template
+	// idle holds renderers that are not in use, by the name of the page
+	// or partialsKey.
+	idle map[string][]*renderer
+}
+
+// partialsKey is the idle renderers key of the partials template. It cannot
+// be a page name, because those never contain a slash.
+const partialsKey = "partials/"
+
+// renderer executes a clone of a parsed template, with template functions
+// that read the request from its state. Templates are escaped when executed
+// for the first time, which is expensive, so a renderer is reused by the
+// following requests, one at a time.
+type renderer struct {
+	tmpl       *template.Template
+	state      *renderState
+	generation int
+}
+
+// renderState is the request that a renderer renders for.
+type renderState struct {
+	r      *http.Request
+	locale *i18n.Locale
+	loc    *time.Location
+}
+
+func (s *renderState) bind(r *http.Request) {
+	if r == nil {
+		*s = renderState{}
+		return
+	}
+	*s = renderState{
+		r:      r,
+		locale: i18n.FromContext(r.Context()),
+		loc:    viewerLocation(r),
+	}
+}
+
+func mustLoadTemplates(fsys fs.FS, dir string) *templateSet {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	partials, pages, err := parsePages(sub)
+	if err != nil {
+		panic(err)
+	}
+	return &templateSet{fsys: sub, pages: pages, partials: partials}
+}
+
+// newReloadingTemplates returns a template set that is parsed from given file
+// system when first used and parsed again each time a file changes.
+func newReloadingTemplates(fsys fs.FS) *templateSet {
+	return &templateSet{fsys: fsys, reload: true}
+}
+
+// ExecutePage writes the page with given name, rendered within the layout for
+// given request.
+func (s *templateSet) ExecutePage(w io.Writer, r *http.Request, page string, data interface{}) error {
+	return s.execute(w, r, page, "layout", data)
+}
+
+// ExecutePartial writes the partial with given name for given request,
+// without the layout. It allows to render a part of a page on its own.
+func (s *templateSet) ExecutePartial(w io.Writer, r *http.Request, partial string, data interface{}) error {
+	return s.execute(w, r, partialsKey, partial, data)
+}
+
+func (s *templateSet) execute(w io.Writer, r *http.Request, key, name string, data interface{}) error {
+	rd, err := s.take(key)
+	if err != nil {
+		return err
+	}
+	defer s.release(key, rd)
+
+	rd.state.bind(r)
+	defer rd.state.bind(nil)
+	return rd.tmpl.ExecuteTemplate(w, name, data)
+}
+
+// take returns an idle renderer of the template with given key, or a new one
+// if all are in use.
+func (s *templateSet) take(key string) (*renderer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	if idle := s.idle[key]; len(idle) != 0 {
+		rd := idle[len(idle)-1]
+		s.idle[key] = idle[:len(idle)-1]
+		return rd, nil
+	}
+
+	base := s.partials
+	if key != partialsKey {
+		t, ok := s.pages[key]
+		if !ok {
+			return nil, fmt.Errorf("page %q does not exist", key)
+		}
+		base = t
+	}
+	// Parsed templates are never executed, so that they can be cloned.
+	t, err := base.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("clone template: %w", err)
+	}
+	state := &renderState{}
+	return &renderer{
+		tmpl:       t.Funcs(requestFuncs(state)),
+		state:      state,
+		generation: s.generation,
+	}, nil
+}
+
+func (s *templateSet) release(key string, rd *renderer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rd.generation != s.generation {
+		return
+	}
+	if s.idle == nil {
+		s.idle = make(map[string][]*renderer)
+	}
+	s.idle[key] = append(s.idle[key], rd)
+}
+
+// reloadIfChanged parses templates again if reloading is enabled and any of
+// the files changed. It must be called with the mutex held.
+func (s *templateSet) reloadIfChanged() error {
+	if !s.reload {
+		return nil
+	}
+	version, err := filesVersion(s.fsys)
+	if err != nil {
+		return fmt.Errorf("stat templates: %w", err)
+	}
+	if version != s.version || s.pages == nil {
+		partials, pages, err := parsePages(s.fsys)
+		if err != nil {
+			return err
+		}
+		s.partials, s.pages, s.version = partials, pages, version
+		s.generation++
+		s.idle = nil
+	}
+	return nil
+}
+
+// Names returns names of all pages.
+func (s *templateSet) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.pages))
+	for name := range s.pages {
+		names = append(names, name)
+	}
+	return names
+}
+
+// parsePages returns the template with the layout and partials only, and
+// templates of all pages by name.
+func parsePages(fsys fs.FS) (*template.Template, map[string]*template.Template, error) {
+	base, err := template.New("").Funcs(templateFuncs).Funcs(parseFuncs()).ParseFS(fsys, "layout.html", "partials/*.html")
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse layout: %w", err)
+	}
+	files, err := fs.Glob(fsys, "pages/*.html")
+	if err != nil {
+		return nil, nil, err
+	}
+	pages := make(map[string]*template.Template, len(files))
+	for _, file := range files {
+		t, err := base.Clone()
+		if err != nil {
+			return nil, nil, fmt.Errorf("clone layout: %w", err)
+		}
+		if t, err = t.ParseFS(fsys, file); err != nil {
+			return nil, nil, fmt.Errorf("parse page: %w", err)
+		}
+		pages[strings.TrimSuffix(path.Base(file), ".html")] = t
+	}
+	return base, pages, nil
+}
+
+// parseFuncs returns request specific template functions that templates are
+// parsed with. Functions bound to a request are placeholders that fail when
+// called, because there is no request at parse time. All of them are
+// replaced with requestFuncs before a template is executed.
+func parseFuncs() template.FuncMap {
+	funcs := template.FuncMap{}
+	for _, name := range []string{"csrfToken", "cspNonce", "profile", "viewer", "requestURI"} {
+		funcs[name] = unboundFunc(name)
+	}
+	utc := func() *time.Location { return time.UTC }
+	english := func() *i18n.Locale { return i18n.English }
+	for name, fn := range timeFuncs(utc, time.Now, english) {
+		funcs[name] = fn
+	}
+	for name, fn := range translationFuncs(english) {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+func unboundFunc(name string) func(...interface{}) (interface{}, error) {
+	return func(...interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("%s template function is not bound to a request", name)
+	}
+}
+
+// templatesMiddleware makes given template set available to all handlers,
+// so that pages are rendered using it.
+func templatesMiddleware(s *templateSet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), templatesContextKey, s)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requestTemplates returns the template set of the application serving the
+// request. Embedded templates are returned if none was set.
+func requestTemplates(ctx context.Context) *templateSet {
+	if s, ok := ctx.Value(templatesContextKey).(*templateSet); ok {
+		return s
+	}
+	return embeddedTemplates
+}
+
+// filesVersion returns a description of all files in given file system that
+// changes whenever any of the files is created, modified or removed.
+func filesVersion(fsys fs.FS) (string, error) {
+	var b strings.Builder
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", name, fi.Size(), fi.ModTime().UnixNano())
+		return nil
+	})
+	return b.String(), err
+}
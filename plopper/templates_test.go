@@ -0,0 +1,276 @@
+package plopper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/husio/plopper/i18n"
+	"github.com/husio/plopper/lith"
+)
+
+func TestRenderEveryPage(t *testing.T) {
+	createdAt := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	plop := &Plop{
+		ID:        PlopID{0xab, 0xcd},
+		AuthorID:  "alice",
+		CreatedAt: createdAt,
+		EditedAt:  createdAt.Add(time.Minute),
+		Content:   "Hello <world>",
+		Status:    PlopHeld,
+	}
+	account := &lith.AccountSession{AccountID: "bob", Permissions: []string{"plop:create", "plop:moderate"}}
+
+	// Each page is rendered with the data its handler provides. Every
+	// rendered page must contain its expectation.
+	fixtures := map[string]struct {
+		data interface{}
+		want string
+	}{
+		"status": {
+			data: statusView{Code: 202, Title: "Accepted", Description: "Your plop awaits moderation."},
+			want: "Your plop awaits moderation.",
+		},
+		"error-4xx": {
+			data: statusView{Code: 400, Title: "Bad Request", Description: "Something went wrong.", RequestID: "r3qu3st"},
+			want: "Request ID: <code>r3qu3st</code>",
+		},
+		"error-5xx": {
+			data: statusView{Code: 500, Title: "Internal Server Error", RequestID: "r3qu3st"},
+			want: "request ID <code>r3qu3st</code>",
+		},
+		"list-plops": {
+			data: listPlopsView{Plops: []*Plop{plop}, Account: account, Timeline: timelineFollowing, NextPage: "cursor"},
+			want: "Hello &lt;world&gt;",
+		},
+		"author": {
+			data: authorView{AuthorID: "alice", Account: account, Followers: []string{"bob"}, Plops: []*Plop{plop}},
+			want: "1 follower",
+		},
+		"show-plop": {
+			data: plop,
+			want: `id="plop-abcd"`,
+		},
+		"report-plop": {
+			data: reportPlopView{Plop: plop, ReasonLimits: contentLimits{MinLength: 1, MaxLength: 1024, MaxNewlines: 2}},
+			want: `action="/report/abcd"`,
+		},
+		"relations": {
+			data: struct {
+				Relations []*Relation
+			}{Relations: []*Relation{{AccountID: "bob", TargetID: "alice", Kind: RelationBlock, CreatedAt: createdAt}}},
+			want: "Unblock",
+		},
+		"settings": {
+			data: settingsView{
+				Profile: &Profile{AccountID: "bob", Bio: "About me", Theme: themeDark},
+				Errors:  map[string]string{"bio": "Bio is invalid."},
+				Saved:   true,
+			},
+			want: "Bio is invalid.",
+		},
+		"moderation-queue": {
+			data: struct {
+				Plops []*Plop
+			}{Plops: []*Plop{plop}},
+			want: `value="approve"`,
+		},
+		"moderation-reports": {
+			data: struct {
+				Reports []*Report
+			}{Reports: []*Report{{ID: 1, Plop: plop, ReporterID: "bob", Reason: "Spam", CreatedAt: createdAt}}},
+			want: "Spam",
+		},
+		"moderation-log": {
+			data: struct {
+				Actions []*ModerationAction
+			}{Actions: []*ModerationAction{{ID: 1, PlopID: plop.ID, AuthorID: "alice", ModeratorID: "bob", Action: ActionHide, Note: "Rude", CreatedAt: createdAt}}},
+			want: "Rude",
+		},
+	}
+
+	for _, name := range embeddedTemplates.Names() {
+		if _, ok := fixtures[name]; !ok {
+			t.Errorf("no fixture for %q page", name)
+		}
+	}
+
+	for _, l := range i18n.Locales() {
+		for name, fx := range fixtures {
+			t.Run(l.Tag+"/"+name, func(t *testing.T) {
+				r := httptest.NewRequest("GET", "/", nil)
+				ctx := i18n.NewContext(r.Context(), l)
+				ctx = context.WithValue(ctx, viewerProfileContextKey, &Profile{AccountID: "bob", Theme: themeDark})
+				r = r.WithContext(ctx)
+
+				var b bytes.Buffer
+				if err := executeTemplate(&b, r, name, fx.data); err != nil {
+					t.Fatalf("cannot render: %s", err)
+				}
+				html := b.String()
+				if !strings.HasPrefix(html, "<!doctype html>") || !strings.Contains(html, "<footer>") {
+					t.Fatalf("page is not rendered within the layout:\n%s", html)
+				}
+				if !strings.Contains(html, `<html lang="`+l.Tag+`" data-theme="dark">`) {
+					t.Fatalf("layout does not use the request information:\n%s", html)
+				}
+				if l == i18n.English && !strings.Contains(html, fx.want) {
+					t.Fatalf("want %q in the page:\n%s", fx.want, html)
+				}
+			})
+		}
+	}
+}
+
+func TestReloadingTemplates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	render := func(s *templateSet, page string) (string, error) {
+		t.Helper()
+		var b bytes.Buffer
+		err := s.ExecutePage(&b, httptest.NewRequest("GET", "/", nil), page, nil)
+		return b.String(), err
+	}
+
+	// Each change alters the file size, so that it is noticed even if the
+	// modification time resolution of the file system is low.
+	write("layout.html", `{{define "layout"}}[{{template "content" .}}]{{end}}`)
+	write("partials/greeting.html", `{{define "greeting"}}hello{{end}}`)
+	write("pages/index.html", `{{define "content"}}{{template "greeting"}}{{end}}`)
+
+	s := newReloadingTemplates(os.DirFS(dir))
+	if got, err := render(s, "index"); err != nil || got != "[hello]" {
+		t.Fatalf("want [hello], got %q, %v", got, err)
+	}
+
+	write("partials/greeting.html", `{{define "greeting"}}hello again{{end}}`)
+	if got, err := render(s, "index"); err != nil || got != "[hello again]" {
+		t.Fatalf("partial change: want [hello again], got %q, %v", got, err)
+	}
+
+	write("pages/about.html", `{{define "content"}}about{{end}}`)
+	if got, err := render(s, "about"); err != nil || got != "[about]" {
+		t.Fatalf("new page: want [about], got %q, %v", got, err)
+	}
+
+	write("pages/index.html", `{{define "content"}}{{template "greeting"}{{end}}`)
+	if _, err := render(s, "index"); err == nil {
+		t.Fatal("want a parse error")
+	}
+	write("pages/index.html", `{{define "content"}}fixed{{end}}`)
+	if got, err := render(s, "index"); err != nil || got != "[fixed]" {
+		t.Fatalf("fixed page: want [fixed], got %q, %v", got, err)
+	}
+}
+
+func TestRenderersAreReused(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"layout.html":          `{{define "layout"}}{{locale.Tag}} {{template "content" .}}{{end}}`,
+		"partials/number.html": `{{define "number"}}{{.}}{{end}}`,
+		"pages/index.html":     `{{define "content"}}{{template "number" .}}{{end}}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newReloadingTemplates(os.DirFS(dir))
+
+	for i, l := range i18n.Locales() {
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(i18n.NewContext(r.Context(), l))
+		var b bytes.Buffer
+		if err := s.ExecutePage(&b, r, "index", i); err != nil {
+			t.Fatalf("cannot render: %s", err)
+		}
+		if want := fmt.Sprintf("%s %d", l.Tag, i); b.String() != want {
+			t.Fatalf("want %q, got %q", want, b.String())
+		}
+	}
+	if n := len(s.idle["index"]); n != 1 {
+		t.Fatalf("want a single renderer used by all requests, got %d", n)
+	}
+}
+
+func TestApplicationTemplates(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"layout.html":           `{{define "layout"}}custom {{template "content" .}}{{end}}`,
+		"partials/nothing.html": `{{define "nothing"}}{{end}}`,
+		"pages/list-plops.html": `{{define "content"}}list{{end}}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := OpenSQLitePlopStore(":memory:")
+	if err != nil {
+		t.Fatalf("cannot open plop store: %s", err)
+	}
+	defer store.Close()
+	custom := DefaultSettings()
+	custom.TemplateDir = dir
+	customApp := newTestApplication(t, store, custom)
+	defaultApp := newTestApplication(t, store, DefaultSettings())
+
+	get := func(app http.Handler) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Body.String()
+	}
+	if got := get(customApp); got != "custom list" {
+		t.Fatalf("want page from the template directory, got %q", got)
+	}
+	// Each application uses its own templates.
+	if got := get(defaultApp); !strings.HasPrefix(got, "<!doctype html>") {
+		t.Fatalf("want embedded templates used, got %q", got)
+	}
+}
+
+func TestParseFuncs(t *testing.T) {
+	parse := parseFuncs()
+	request := requestFuncs(&renderState{})
+	for name := range request {
+		if _, ok := parse[name]; !ok {
+			t.Errorf("%s is not declared for parsing", name)
+		}
+	}
+	for name := range parse {
+		if _, ok := request[name]; !ok {
+			t.Errorf("%s is declared for parsing only", name)
+		}
+	}
+
+	tmpl := template.Must(template.New("").Funcs(parse).Parse(`{{csrfToken}}`))
+	if err := tmpl.Execute(io.Discard, nil); err == nil {
+		t.Fatal("want placeholder to fail without a request")
+	}
+}
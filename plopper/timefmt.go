@@ -0,0 +1,127 @@
+package plopper
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/husio/plopper/i18n"
+)
+
+const (
+	// timezoneCookie holds the IANA time zone name of the browser. It is
+	// set by the JavaScript.
+	timezoneCookie = "tz"
+	// timezoneHeader allows API clients to provide their IANA time zone
+	// name.
+	timezoneHeader = "Time-Zone"
+
+	absTimeFmt = "2 Jan 2006 15:04 MST"
+	dateFmt    = "2 Jan 2006"
+)
+
+// viewerLocation returns the time zone that times should be presented in to
+// the client. The time zone chosen in the profile settings takes precedence
+// over the one reported by the browser in a cookie, which takes precedence
+// over the request header. UTC is used if none is provided.
+func viewerLocation(r *http.Request) *time.Location {
+	if r == nil {
+		return time.UTC
+	}
+	if tz := viewerProfile(r.Context()).Timezone; tz != "" {
+		if loc, ok := loadLocation(tz); ok {
+			return loc
+		}
+	}
+	if c, err := r.Cookie(timezoneCookie); err == nil {
+		if loc, ok := loadLocation(c.Value); ok {
+			return loc
+		}
+	}
+	if loc, ok := loadLocation(r.Header.Get(timezoneHeader)); ok {
+		return loc
+	}
+	return time.UTC
+}
+
+// loadLocation returns the location with given IANA name. The server's local
+// time zone is never returned. Locations are loaded from the time zone
+// database once and cached, including names that are not known.
+func loadLocation(name string) (*time.Location, bool) {
+	if name == "" || name == "Local" {
+		return nil, false
+	}
+	if loc, ok := locations.Load(name); ok {
+		loc, _ := loc.(*time.Location)
+		return loc, loc != nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = nil
+	}
+	// Names are provided by clients, so the number of cached ones is
+	// limited. The time zone database contains fewer names than that.
+	if atomic.AddInt32(&locationsCached, 1) <= maxLocationsCached {
+		locations.Store(name, loc)
+	}
+	return loc, loc != nil
+}
+
+var (
+	// locations caches loaded locations by name. Unknown names are
+	// stored with a nil location.
+	locations       sync.Map
+	locationsCached int32
+)
+
+const maxLocationsCached = 2048
+
+// timeFuncs returns template functions that present time in the location and
+// the language returned by loc and l. Relative times are computed against the
+// time returned by now.
+func timeFuncs(loc func() *time.Location, now func() time.Time, l func() *i18n.Locale) template.FuncMap {
+	return template.FuncMap{
+		// localTime returns given time in the time zone of the viewer.
+		"localTime": func(t time.Time) time.Time { return t.In(loc()) },
+		// absTime returns the date and the time in the time zone of the
+		// viewer, for example "2 Jan 2006 15:04 CET".
+		"absTime": func(t time.Time) string { return t.In(loc()).Format(l().T(absTimeFmt)) },
+		// relTime returns how long ago given time was, for example "5
+		// minutes ago".
+		"relTime": func(t time.Time) string { return relativeTime(t.In(loc()), now(), l()) },
+		// isoTime returns given time in the format of the datetime
+		// attribute.
+		"isoTime": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+		// timeTag returns a <time> element presenting given time relative
+		// to now, with the absolute time in the title.
+		"timeTag": func(t time.Time) template.HTML {
+			t = t.In(loc())
+			return template.HTML(fmt.Sprintf(`<time datetime="%s" title="%s">%s</time>`,
+				t.UTC().Format(time.RFC3339),
+				template.HTMLEscapeString(t.Format(l().T(absTimeFmt))),
+				template.HTMLEscapeString(relativeTime(t, now(), l()))))
+		},
+	}
+}
+
+// relativeTime returns a human readable description of how long before now
+// given time was. Times older than a week are presented as a date, in the
+// location of given time. Times in the future, for example because of clock
+// skew, are presented as "just now".
+func relativeTime(t, now time.Time, l *i18n.Locale) string {
+	switch d := now.Sub(t); {
+	case d < time.Minute:
+		return l.T("just now")
+	case d < time.Hour:
+		return l.N(int(d/time.Minute), "%d minute ago", "%d minutes ago")
+	case d < 24*time.Hour:
+		return l.N(int(d/time.Hour), "%d hour ago", "%d hours ago")
+	case d < 7*24*time.Hour:
+		return l.N(int(d/(24*time.Hour)), "%d day ago", "%d days ago")
+	default:
+		return t.Format(l.T(dateFmt))
+	}
+}
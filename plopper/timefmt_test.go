@@ -0,0 +1,206 @@
+package plopper
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/husio/plopper/i18n"
+)
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		t    time.Time
+		want string
+	}{
+		"now":           {t: now, want: "just now"},
+		"future":        {t: now.Add(time.Hour), want: "just now"},
+		"seconds":       {t: now.Add(-59 * time.Second), want: "just now"},
+		"one minute":    {t: now.Add(-time.Minute), want: "1 minute ago"},
+		"minutes":       {t: now.Add(-5*time.Minute - 30*time.Second), want: "5 minutes ago"},
+		"one hour":      {t: now.Add(-time.Hour), want: "1 hour ago"},
+		"hours":         {t: now.Add(-23*time.Hour - 59*time.Minute), want: "23 hours ago"},
+		"one day":       {t: now.Add(-24 * time.Hour), want: "1 day ago"},
+		"days":          {t: now.Add(-6 * 24 * time.Hour), want: "6 days ago"},
+		"week and more": {t: now.Add(-7 * 24 * time.Hour), want: "3 Mar 2022"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := relativeTime(tc.t, now, i18n.English); got != tc.want {
+				t.Fatalf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRelativeTimeTranslated(t *testing.T) {
+	pl, ok := i18n.Lookup("pl")
+	if !ok {
+		t.Fatal("pl locale not found")
+	}
+	now := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	cases := map[time.Duration]string{
+		0:                   "przed chwilą",
+		time.Minute:         "1 minutę temu",
+		3 * time.Minute:     "3 minuty temu",
+		5 * time.Minute:     "5 minut temu",
+		22 * time.Minute:    "22 minuty temu",
+		12 * time.Hour:      "12 godzin temu",
+		2 * 24 * time.Hour:  "2 dni temu",
+		30 * 24 * time.Hour: "08.02.2022",
+	}
+	for ago, want := range cases {
+		if got := relativeTime(now.Add(-ago), now, pl); got != want {
+			t.Errorf("%s: want %q, got %q", ago, want, got)
+		}
+	}
+}
+
+func TestViewerLocation(t *testing.T) {
+	withProfile := func(r *http.Request, tz string) *http.Request {
+		ctx := context.WithValue(r.Context(), viewerProfileContextKey, &Profile{AccountID: "alice", Timezone: tz})
+		return r.WithContext(ctx)
+	}
+	withCookie := func(r *http.Request, tz string) *http.Request {
+		r.AddCookie(&http.Cookie{Name: timezoneCookie, Value: tz})
+		return r
+	}
+	withHeader := func(r *http.Request, tz string) *http.Request {
+		r.Header.Set(timezoneHeader, tz)
+		return r
+	}
+
+	cases := map[string]struct {
+		request *http.Request
+		want    string
+	}{
+		"nothing provided": {
+			request: httptest.NewRequest("GET", "/", nil),
+			want:    "UTC",
+		},
+		"header": {
+			request: withHeader(httptest.NewRequest("GET", "/", nil), "America/New_York"),
+			want:    "America/New_York",
+		},
+		"cookie over header": {
+			request: withCookie(withHeader(httptest.NewRequest("GET", "/", nil), "America/New_York"), "Europe/Warsaw"),
+			want:    "Europe/Warsaw",
+		},
+		"profile over cookie": {
+			request: withProfile(withCookie(httptest.NewRequest("GET", "/", nil), "Europe/Warsaw"), "Asia/Tokyo"),
+			want:    "Asia/Tokyo",
+		},
+		"invalid cookie ignored": {
+			request: withCookie(withHeader(httptest.NewRequest("GET", "/", nil), "America/New_York"), "Nowhere/Land"),
+			want:    "America/New_York",
+		},
+		"server local time zone ignored": {
+			request: withHeader(httptest.NewRequest("GET", "/", nil), "Local"),
+			want:    "UTC",
+		},
+		"no request": {
+			request: nil,
+			want:    "UTC",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := viewerLocation(tc.request).String(); got != tc.want {
+				t.Fatalf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadLocationCached(t *testing.T) {
+	first, ok := loadLocation("Europe/Warsaw")
+	if !ok {
+		t.Fatal("Europe/Warsaw not loaded")
+	}
+	if second, _ := loadLocation("Europe/Warsaw"); second != first {
+		t.Fatal("location loaded again")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, ok := loadLocation("Nowhere/Atlantis"); ok {
+			t.Fatal("unknown location loaded")
+		}
+	}
+	if loc, ok := locations.Load("Nowhere/Atlantis"); !ok || loc.(*time.Location) != nil {
+		t.Fatalf("unknown location not cached, got %v", loc)
+	}
+}
+
+func TestTimeFuncs(t *testing.T) {
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	funcs := timeFuncs(
+		func() *time.Location { return warsaw },
+		func() time.Time { return now },
+		func() *i18n.Locale { return i18n.English },
+	)
+
+	cases := map[string]struct {
+		tmpl string
+		t    time.Time
+		want string
+	}{
+		"local time": {
+			tmpl: `{{(localTime .).Format "15:04"}}`,
+			t:    time.Date(2022, 3, 10, 23, 30, 0, 0, time.UTC),
+			want: "00:30",
+		},
+		"absolute time": {
+			tmpl: `{{absTime .}}`,
+			t:    time.Date(2022, 3, 10, 23, 30, 0, 0, time.UTC),
+			want: "11 Mar 2022 00:30 CET",
+		},
+		"relative time": {
+			tmpl: `{{relTime .}}`,
+			t:    now.Add(-3 * time.Hour),
+			want: "3 hours ago",
+		},
+		"relative time falls back to local date": {
+			tmpl: `{{relTime .}}`,
+			t:    time.Date(2022, 2, 1, 23, 30, 0, 0, time.UTC),
+			want: "2 Feb 2022",
+		},
+		"datetime attribute": {
+			tmpl: `<time datetime="{{isoTime .}}">`,
+			t:    time.Date(2022, 3, 10, 11, 0, 0, 0, warsaw),
+			want: `<time datetime="2022-03-10T10:00:00Z">`,
+		},
+		"time element": {
+			tmpl: `{{timeTag .}}`,
+			t:    now.Add(-5 * time.Minute),
+			want: `<time datetime="2022-03-10T11:55:00Z" title="10 Mar 2022 12:55 CET">5 minutes ago</time>`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			tmpl := template.Must(template.New("").Funcs(funcs).Parse(tc.tmpl))
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, tc.t); err != nil {
+				t.Fatalf("cannot execute: %s", err)
+			}
+			if got := b.String(); got != tc.want {
+				t.Fatalf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+
+	// All helpers must be available to the application templates.
+	for name := range funcs {
+		if _, ok := requestFuncs(&renderState{})[name]; !ok {
+			t.Errorf("%s is not registered as a request function", name)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Package tracing implements spans that describe the time spent on an
+// operation, grouped into traces. Trace context is propagated between
+// services using the W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter receives all finished spans.
+type Exporter interface {
+	ExportSpan(*SpanData)
+}
+
+// SpanData is the description of a finished span.
+type SpanData struct {
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Start      time.Time         `json:"start"`
+	Duration   time.Duration     `json:"duration_ns"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Tracer creates spans and passes them to the exporter once they end.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a tracer that exports spans using given exporter. If
+// exporter is nil, spans are created and propagated, but not exported.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Default is the tracer used by all package level functions.
+var Default = NewTracer(nil)
+
+// Start returns a new span using the Default tracer. See Tracer.Start.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return Default.Start(ctx, name)
+}
+
+// Start returns a new span and a context carrying it. If the context carries
+// a span or a remote span context, the new span is its child. Otherwise a
+// new trace is started.
+//
+// Returned span must be ended by calling End.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	s := &Span{
+		tracer: t,
+		name:   name,
+		start:  time.Now(),
+		spanID: newSpanID(),
+	}
+	if parent, ok := ctx.Value(spanContextKey).(SpanContext); ok {
+		s.traceID = parent.TraceID
+		s.parentID = parent.SpanID
+	} else {
+		s.traceID = newTraceID()
+	}
+	return context.WithValue(ctx, spanContextKey, s.SpanContext()), s
+}
+
+// Span describes a single operation.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	start    time.Time
+	traceID  string
+	spanID   string
+	parentID string
+
+	mu    sync.Mutex
+	attrs map[string]string
+	err   string
+	ended bool
+}
+
+// SpanContext returns identifiers that allow to create child spans.
+func (s *Span) SpanContext() SpanContext {
+	return SpanContext{TraceID: s.traceID, SpanID: s.spanID}
+}
+
+// SetAttribute attaches a key-value pair describing the operation.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]string)
+	}
+	s.attrs[key] = fmt.Sprint(value)
+	s.mu.Unlock()
+}
+
+// RecordError marks the operation as failed. Nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and passes it to the exporter. Only the first call
+// has an effect.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := &SpanData{
+		TraceID:    s.traceID,
+		SpanID:     s.spanID,
+		ParentID:   s.parentID,
+		Name:       s.name,
+		Start:      s.start,
+		Duration:   time.Since(s.start),
+		Attributes: s.attrs,
+		Error:      s.err,
+	}
+	s.mu.Unlock()
+
+	if s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(data)
+	}
+}
+
+// SpanContext identifies a span, possibly created by another service.
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+}
+
+// FromContext returns the context of the current span.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey).(SpanContext)
+	return sc, ok
+}
+
+// WithRemoteParent returns a context carrying span context of a span created
+// by another service. Spans started with returned context are its children.
+func WithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey, sc)
+}
+
+// Inject sets the traceparent header describing the current span.
+func Inject(ctx context.Context, h http.Header) {
+	if sc, ok := FromContext(ctx); ok {
+		h.Set("traceparent", "00-"+sc.TraceID+"-"+sc.SpanID+"-01")
+	}
+}
+
+// Extract returns span context described by the traceparent header.
+func Extract(h http.Header) (SpanContext, bool) {
+	chunks := strings.Split(h.Get("traceparent"), "-")
+	if len(chunks) != 4 || chunks[0] != "00" {
+		return SpanContext{}, false
+	}
+	if !isHex(chunks[1], 32) || !isHex(chunks[2], 16) || !isHex(chunks[3], 2) {
+		return SpanContext{}, false
+	}
+	// All zero identifiers are invalid.
+	if strings.Trim(chunks[1], "0") == "" || strings.Trim(chunks[2], "0") == "" {
+		return SpanContext{}, false
+	}
+	return SpanContext{TraceID: chunks[1], SpanID: chunks[2]}, true
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newTraceID() string {
+	return randomHex(16)
+}
+
+func newSpanID() string {
+	return randomHex(8)
+}
+
+func randomHex(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware returns an http.Handler middleware that starts a span for each
+// request. If the request contains a valid traceparent header, the span
+// continues the trace of the caller.
+func Middleware(t *Tracer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &middleware{tracer: t, next: next}
+	}
+}
+
+type middleware struct {
+	tracer *Tracer
+	next   http.Handler
+}
+
+func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if sc, ok := Extract(r.Header); ok {
+		ctx = WithRemoteParent(ctx, sc)
+	}
+	ctx, span := m.tracer.Start(ctx, "HTTP "+r.Method)
+	defer span.End()
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.path", r.URL.Path)
+
+	rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	m.next.ServeHTTP(rec, r.WithContext(ctx))
+	span.SetAttribute("http.status_code", rec.code)
+	if rec.code >= 500 {
+		span.RecordError(fmt.Errorf("%d %s", rec.code, http.StatusText(rec.code)))
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.code = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Transport returns an http.RoundTripper that creates a span for each
+// outgoing request and propagates the trace using the traceparent header.
+func Transport(t *Tracer, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{tracer: t, next: next}
+}
+
+type transport struct {
+	tracer *Tracer
+	next   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := t.tracer.Start(req.Context(), "HTTP "+req.Method+" "+req.URL.Host)
+	defer span.End()
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL)
+
+	// RoundTripper must not modify the original request.
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		span.RecordError(fmt.Errorf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)))
+	}
+	return resp, nil
+}
+
+type contextKey int
+
+const (
+	spanContextKey contextKey = iota
+)
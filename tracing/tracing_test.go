@@ -0,0 +1,122 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*SpanData
+}
+
+func (e *recordingExporter) ExportSpan(s *SpanData) {
+	e.mu.Lock()
+	e.spans = append(e.spans, s)
+	e.mu.Unlock()
+}
+
+func TestExtract(t *testing.T) {
+	cases := map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00": true,
+		"": false,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": false,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01": false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01": false,
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01": false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7":    false,
+	}
+	for header, valid := range cases {
+		h := http.Header{}
+		h.Set("traceparent", header)
+		sc, ok := Extract(h)
+		if ok != valid {
+			t.Errorf("%q: want valid=%v", header, valid)
+			continue
+		}
+		if ok && (sc.TraceID != header[3:35] || sc.SpanID != header[36:52]) {
+			t.Errorf("%q: unexpected span context %+v", header, sc)
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	var exporter recordingExporter
+	tracer := NewTracer(&exporter)
+
+	var outgoing http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outgoing = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: Transport(tracer, nil)}
+
+	handler := Middleware(tracer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), "inner")
+		defer span.End()
+		req, _ := http.NewRequestWithContext(ctx, "GET", upstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("upstream request: %s", err)
+			return
+		}
+		resp.Body.Close()
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(exporter.spans) != 3 {
+		t.Fatalf("want 3 spans, got %d", len(exporter.spans))
+	}
+	// Spans are exported when they end, so the innermost one is first.
+	outbound, inner, server := exporter.spans[0], exporter.spans[1], exporter.spans[2]
+	for _, s := range exporter.spans {
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("%s: unexpected trace ID %s", s.Name, s.TraceID)
+		}
+	}
+	if server.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("server span parent: %s", server.ParentID)
+	}
+	if inner.ParentID != server.SpanID {
+		t.Errorf("inner span parent: %s", inner.ParentID)
+	}
+	if outbound.ParentID != inner.SpanID {
+		t.Errorf("outbound span parent: %s", outbound.ParentID)
+	}
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + outbound.SpanID + "-01"; outgoing.Get("traceparent") != want {
+		t.Errorf("want %q traceparent, got %q", want, outgoing.Get("traceparent"))
+	}
+	if server.Attributes["http.status_code"] != "200" {
+		t.Errorf("unexpected server span attributes: %v", server.Attributes)
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var b bytes.Buffer
+	tracer := NewTracer(NewJSONExporter(&b))
+	_, span := tracer.Start(context.Background(), "work")
+	span.SetAttribute("n", 42)
+	span.End()
+	span.End()
+
+	var got SpanData
+	dec := json.NewDecoder(&b)
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if got.Name != "work" || got.Attributes["n"] != "42" || len(got.TraceID) != 32 || len(got.SpanID) != 16 {
+		t.Fatalf("unexpected span: %+v", got)
+	}
+	if dec.More() {
+		t.Fatal("span exported more than once")
+	}
+}